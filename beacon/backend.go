@@ -2,12 +2,31 @@ package beacon
 
 // Backend recieves events routed to it by Beacon.
 type Backend interface {
-	// ProcessEvent instructs the backend to handle an event. This is called in
-	// the main event processing loop and so should not block. If ProcessEvent
-	// panics it will fail through the Beacon Run function.
+	// ProcessEvent instructs the backend to handle an event. Beacon calls this
+	// from the route's queue goroutine so a slow backend only delays its own
+	// events.
 	ProcessEvent(event *Event) error
 
 	// Close frees any resources associated with the backend. It blocks until
 	// the backend completes processing of all in-flight events.
 	Close() error
 }
+
+// FailureReporter is implemented by backends which accept events before they
+// are delivered, such as those which send events in batches. The route's queue
+// calls SetFailureHandler with its retry policy, which such a backend applies
+// to the events it retries, and a function which dead letters the events that
+// the backend fails to deliver.
+type FailureReporter interface {
+	SetFailureHandler(retry RetryPolicy, handler func(event *Event, err error, attempts int))
+}
+
+// Syncer is implemented by backends which keep their own record of the
+// containers, such as service registries, so that they can correct it after
+// Beacon restarts. Once the runtime has reported its running containers the
+// route's queue calls Sync with the containers which match the route, after the
+// events queued before them. Routes added later are synced after their Start
+// events.
+type Syncer interface {
+	Sync(containers []*Container) error
+}
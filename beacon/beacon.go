@@ -1,14 +1,28 @@
 package beacon
 
 import (
+	"github.com/BlueDragonX/beacon/metrics"
 	"github.com/pkg/errors"
 	"sync"
 )
 
 // New creates a Beacon which receieves events from the `runtime` and uses
-// `routes` to queue them into appropriate backends. New does not start the
-// Beacon.
+// `routes` to queue them into appropriate backends. Routes which were not
+// created with NewQueue are wrapped in a queue with the default settings. New
+// does not start the Beacon.
 func New(runtime Runtime, routes []Route) (Beacon, error) {
+	return NewWithState(runtime, routes, "")
+}
+
+// NewWithState works like New but persists the containers Beacon discovers to
+// the file at `stateFile`. The file is written whenever a container changes and
+// when Beacon stops.
+//
+// Run loads the saved containers when it starts. Start events from the runtime
+// for saved containers are sent to backends as Update events if the container
+// changed and are otherwise ignored. Saved containers which the runtime does
+// not report before it sends a Synced event are stopped.
+func NewWithState(runtime Runtime, routes []Route, stateFile string) (Beacon, error) {
 	if runtime == nil {
 		return nil, errors.New("runtime cannot be nil")
 	}
@@ -16,11 +30,27 @@ func New(runtime Runtime, routes []Route) (Beacon, error) {
 		return nil, errors.New("routes cannot be empty")
 	}
 	routesCp := make([]Route, len(routes))
-	copy(routesCp, routes)
+	for n, route := range routes {
+		if _, ok := route.(*queue); ok {
+			routesCp[n] = route
+			continue
+		}
+		queued, err := NewQueue(route, QueueConfig{})
+		if err != nil {
+			for _, created := range routesCp[:n] {
+				created.Close()
+			}
+			return nil, err
+		}
+		routesCp[n] = queued
+	}
 	return &beacon{
 		runtime:    runtime,
 		routes:     routesCp,
+		routeLock:  &sync.RWMutex{},
+		stateFile:  stateFile,
 		containers: map[string]*Container{},
+		unseen:     map[string]struct{}{},
 		lock:       &sync.Mutex{},
 	}, nil
 }
@@ -53,24 +83,75 @@ type Beacon interface {
 	// An optional filter may be provided in order to limit the containers
 	// returned.
 	Containers(filter Filter) []*Container
+
+	// Routes retrieves the delivery statistics for each route in the order
+	// the routes were provided.
+	Routes() []*RouteStats
+
+	// Ready returns true once the runtime has reported all of its running
+	// containers. This happens when Run receives a Synced event.
+	Ready() bool
+
+	// AddRoute adds a route to a running Beacon. The route is wrapped in a
+	// queue with the default settings if it was not created with NewQueue.
+	// The route is sent a Start event for each container it matches. Routes
+	// added to a running Beacon must have a unique name. AddRoute fails once
+	// Run has returned.
+	AddRoute(route Route) error
+
+	// RemoveRoute removes the named route and closes it. The route's metrics
+	// are deleted.
+	RemoveRoute(name string) error
+
+	// UpdateFilter replaces the filter on the named route. Only subsequent
+	// events are matched against the new filter. The route must wrap a route
+	// created by NewRoute.
+	UpdateFilter(name string, filter Filter) error
 }
 
 // beacon is the standard Beacon implementation.
 type beacon struct {
 	runtime    Runtime
 	routes     []Route
+	routeLock  *sync.RWMutex
+	stopped    bool // set under the route lock once Run closes the routes
+	stateFile  string
 	containers map[string]*Container
+	unseen     map[string]struct{}
+	synced     bool
 	lock       *sync.Mutex
 }
 
 // Run the beacon.
 func (b *beacon) Run() error {
 	defer func() {
-		for _, route := range b.routes {
-			route.Close()
+		b.routeLock.Lock()
+		b.stopped = true
+		routes := make([]Route, len(b.routes))
+		copy(routes, b.routes)
+		b.routeLock.Unlock()
+		for _, route := range routes {
+			if err := route.Close(); err != nil {
+				Logger.Printf("failed to close route: %s", err)
+			}
 		}
 	}()
 
+	if b.stateFile != "" {
+		containers, err := loadState(b.stateFile)
+		if err != nil {
+			return err
+		}
+		b.lock.Lock()
+		for id, container := range containers {
+			b.containers[id] = container
+			b.unseen[id] = struct{}{}
+		}
+		metrics.Containers.Set(float64(len(b.containers)))
+		b.lock.Unlock()
+		defer b.saveState()
+	}
+
 	events, err := b.runtime.EmitEvents()
 	if err != nil {
 		return errors.Wrap(err, "failed to start runtime")
@@ -81,6 +162,7 @@ func (b *beacon) Run() error {
 		if !ok {
 			break
 		}
+		metrics.Events.WithLabelValues(string(event.Action)).Inc()
 		if err := b.handle(event); err != nil {
 			Logger.Printf("unable to process event: %s\n", err)
 		}
@@ -90,8 +172,103 @@ func (b *beacon) Run() error {
 
 // handle an event
 func (b *beacon) handle(event *Event) error {
+	if event.Action == Synced {
+		return b.reconcile()
+	}
+
+	// update the state and copy the routes under the route lock so that
+	// routes added concurrently see each container change exactly once. The
+	// event is dispatched without the lock so that a full queue does not
+	// block changes to the routes.
+	b.routeLock.RLock()
+	backendEvent, err := b.update(event)
+	routes := make([]Route, len(b.routes))
+	copy(routes, b.routes)
+	b.routeLock.RUnlock()
+	if err != nil || backendEvent == nil {
+		return err
+	}
+	dispatch(routes, backendEvent)
+	return nil
+}
+
+// dispatch sends an event to the routes which match it.
+func dispatch(routes []Route, event *Event) {
+	for _, route := range routes {
+		if route.MatchEvent(event) {
+			if err := route.ProcessEvent(event.Copy()); err != nil {
+				Logger.Printf("discarding event %s for container %s: %s", event.Action, event.Container.ID, err)
+			}
+		}
+	}
+}
+
+// reconcile stops saved containers which the runtime did not report and syncs
+// the routes with the remaining containers.
+func (b *beacon) reconcile() error {
+	b.lock.Lock()
+	ids := make([]string, 0, len(b.unseen))
+	for id := range b.unseen {
+		ids = append(ids, id)
+	}
+	b.unseen = map[string]struct{}{}
+	b.synced = true
+	b.lock.Unlock()
+
+	for _, id := range ids {
+		Logger.Printf("stopping container %s which stopped while beacon was down", id)
+		if err := b.handle(&Event{Action: Stop, Container: &Container{ID: id}}); err != nil {
+			return err
+		}
+	}
+
+	b.routeLock.RLock()
+	defer b.routeLock.RUnlock()
+	containers := b.Containers(nil)
+	for _, route := range b.routes {
+		if q, ok := route.(*queue); ok {
+			q.sync(containers)
+		}
+	}
+	return nil
+}
+
+// saveState writes the container state to the state file.
+func (b *beacon) saveState() {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	b.saveStateLocked()
+}
+
+// saveStateLocked writes the container state to the state file. The caller
+// must hold the lock.
+func (b *beacon) saveStateLocked() {
+	if b.stateFile == "" {
+		return
+	}
+	if err := saveState(b.stateFile, b.containers); err != nil {
+		Logger.Print(err)
+	}
+}
+
+// update the container state with an event. Returns the event to send to the
+// backends or nil if the event results in no change.
+func (b *beacon) update(event *Event) (*Event, error) {
+	if event.Container == nil {
+		return nil, errors.Errorf("missing container for action %s", event.Action)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.unseen, event.Container.ID)
+
+	if event.Action == OOM {
+		// warn the backends without changing the container
+		if container, exists := b.containers[event.Container.ID]; exists {
+			return &Event{Action: OOM, Container: container}, nil
+		}
+		return nil, nil
+	}
 
 	var backendEvent *Event
 	switch event.Action {
@@ -115,7 +292,7 @@ func (b *beacon) handle(event *Event) error {
 			}
 		} else {
 			// no change to an existing container
-			return nil
+			return nil, nil
 		}
 	case Stop:
 		if oldContainer, exists := b.containers[event.Container.ID]; exists {
@@ -127,20 +304,14 @@ func (b *beacon) handle(event *Event) error {
 			}
 		} else {
 			// container already stopped
-			return nil
+			return nil, nil
 		}
 	default:
-		return errors.Errorf("invalid action %s on container %s", event.Action, event.Container.ID)
-	}
-
-	for _, route := range b.routes {
-		if route.MatchContainer(backendEvent.Container) {
-			if err := route.ProcessEvent(backendEvent.Copy()); err != nil {
-				Logger.Printf("discarding event %s for container %s: %s", event.Action, event.Container.ID, err)
-			}
-		}
+		return nil, errors.Errorf("invalid action %s on container %s", event.Action, event.Container.ID)
 	}
-	return nil
+	metrics.Containers.Set(float64(len(b.containers)))
+	b.saveStateLocked()
+	return backendEvent, nil
 }
 
 // Containers returns containers matching the given filter.
@@ -160,6 +331,117 @@ func (b *beacon) Containers(filter Filter) []*Container {
 	return containers
 }
 
+// Routes returns the delivery statistics for each route.
+func (b *beacon) Routes() []*RouteStats {
+	b.routeLock.RLock()
+	defer b.routeLock.RUnlock()
+	stats := make([]*RouteStats, 0, len(b.routes))
+	for _, route := range b.routes {
+		if q, ok := route.(*queue); ok {
+			stats = append(stats, q.Stats())
+		}
+	}
+	return stats
+}
+
+// Ready returns true once the runtime has synced.
+func (b *beacon) Ready() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.synced
+}
+
+// AddRoute adds a route and starts the containers it matches on it.
+func (b *beacon) AddRoute(route Route) error {
+	if route == nil {
+		return errors.New("route cannot be nil")
+	}
+	q, ok := route.(*queue)
+	if !ok {
+		queued, err := NewQueue(route, QueueConfig{})
+		if err != nil {
+			return err
+		}
+		q = queued.(*queue)
+	}
+
+	b.routeLock.Lock()
+	if b.stopped {
+		b.routeLock.Unlock()
+		q.Close()
+		return errors.New("beacon has stopped")
+	}
+	if q.config.Name == "" {
+		b.routeLock.Unlock()
+		q.Close()
+		return errors.New("route name cannot be empty")
+	}
+	if b.findRoute(q.config.Name) >= 0 {
+		b.routeLock.Unlock()
+		q.Close()
+		return errors.Errorf("route %s already exists", q.config.Name)
+	}
+
+	// hold the queue so that events dispatched after the snapshot are queued
+	// after the snapshot's Start events
+	containers := b.Containers(nil)
+	synced := b.Ready()
+	q.hold.Lock()
+	defer q.hold.Unlock()
+	b.routes = append(b.routes, q)
+	b.routeLock.Unlock()
+
+	for _, container := range containers {
+		event := &Event{Action: Start, Container: container}
+		if q.MatchEvent(event) {
+			if err := q.enqueue(event); err != nil {
+				Logger.Printf("discarding event %s for container %s: %s", event.Action, container.ID, err)
+			}
+		}
+	}
+	if synced {
+		q.sync(containers)
+	}
+	return nil
+}
+
+// RemoveRoute removes and closes the named route and deletes its metrics.
+func (b *beacon) RemoveRoute(name string) error {
+	b.routeLock.Lock()
+	n := b.findRoute(name)
+	if n < 0 {
+		b.routeLock.Unlock()
+		return errors.Errorf("route %s not found", name)
+	}
+	route := b.routes[n]
+	b.routes = append(b.routes[:n:n], b.routes[n+1:]...)
+	b.routeLock.Unlock()
+	defer deleteRouteMetrics(name)
+	return route.Close()
+}
+
+// UpdateFilter replaces the filter on the named route.
+func (b *beacon) UpdateFilter(name string, filter Filter) error {
+	b.routeLock.RLock()
+	defer b.routeLock.RUnlock()
+	n := b.findRoute(name)
+	if n < 0 {
+		return errors.Errorf("route %s not found", name)
+	}
+	return b.routes[n].(*queue).setFilter(filter)
+}
+
+// findRoute returns the index of the named route or -1 if there is no such
+// route. The caller must hold the route lock.
+func (b *beacon) findRoute(name string) int {
+	for n, route := range b.routes {
+		if q, ok := route.(*queue); ok && q.config.Name == name {
+			return n
+		}
+	}
+	return -1
+}
+
 // Close the beacon.
 func (b *beacon) Close() error {
 	b.runtime.Close()
@@ -856,3 +856,92 @@ func TestBeaconRunOneStopTwice(t *testing.T) {
 	}
 	runWait.Wait()
 }
+
+func TestBeaconRunActionFilter(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	stopBackend := NewBackend()
+	allBackend := NewBackend()
+
+	routes := []beacon.Route{
+		beacon.NewRoute(beacon.NewActionFilter(nil, []beacon.Action{beacon.Stop}), stopBackend),
+		beacon.NewRoute(nil, allBackend),
+	}
+	bcn, err := beacon.New(runtime, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runWait := &sync.WaitGroup{}
+	runWait.Add(1)
+	go func() {
+		defer runWait.Done()
+		if err := bcn.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	container := &beacon.Container{
+		ID:       "1",
+		Service:  "example",
+		Labels:   map[string]string{"color": "blue"},
+		Bindings: []*beacon.Binding{},
+	}
+	go func() {
+		runtime.Events <- &beacon.Event{Action: beacon.Start, Container: container}
+		runtime.Events <- &beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: "1"}}
+	}()
+
+	eventWait := &sync.WaitGroup{}
+	checkEvents := func(backend *MockBackend, wantEvents []*beacon.Event) {
+		defer eventWait.Done()
+		haveEvents, err := backend.WaitForEvents(len(wantEvents), 5*time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		if err := EventArraysEqual(haveEvents, wantEvents); err != nil {
+			t.Error(err)
+		}
+	}
+
+	eventWait.Add(2)
+	go checkEvents(stopBackend, []*beacon.Event{
+		{Action: beacon.Stop, Container: container},
+	})
+	go checkEvents(allBackend, []*beacon.Event{
+		{Action: beacon.Start, Container: container},
+		{Action: beacon.Stop, Container: container},
+	})
+	eventWait.Wait()
+
+	if err := bcn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	runWait.Wait()
+}
+
+func TestBeaconOOM(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	bcn, backend, stop := runBeacon(t, runtime)
+	defer stop()
+
+	container := &beacon.Container{ID: "1", Service: "example", Labels: map[string]string{"color": "red"}, Bindings: []*beacon.Binding{}}
+	runtime.Events <- &beacon.Event{Action: beacon.Start, Container: container}
+	runtime.Events <- &beacon.Event{Action: beacon.OOM, Container: &beacon.Container{ID: "2"}}
+	runtime.Events <- &beacon.Event{Action: beacon.OOM, Container: &beacon.Container{ID: "1"}}
+	haveEvents, err := backend.WaitForEvents(2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEvents := []*beacon.Event{
+		{Action: beacon.Start, Container: container},
+		{Action: beacon.OOM, Container: container},
+	}
+	if err := EventArraysEqual(haveEvents, wantEvents); err != nil {
+		t.Error(err)
+	}
+	if have := len(bcn.Containers(nil)); have != 1 {
+		t.Errorf("have %d containers, want 1", have)
+	}
+}
@@ -16,6 +16,12 @@ type Container struct {
 
 	// Network port bindings.
 	Bindings []*Binding
+
+	// Exposed ports which are not bound on the host.
+	Ports []*Port
+
+	// Networks the container is attached to.
+	Networks []*Network
 }
 
 // Equal returns true if this container is equal to another.
@@ -25,7 +31,8 @@ func (c *Container) Equal(b *Container) bool {
 	} else if c == nil || b == nil {
 		return false
 	}
-	if c.ID != b.ID || c.Service != b.Service || len(c.Labels) != len(b.Labels) || len(c.Bindings) != len(b.Bindings) {
+	if c.ID != b.ID || c.Service != b.Service || len(c.Labels) != len(b.Labels) || len(c.Bindings) != len(b.Bindings) ||
+		len(c.Ports) != len(b.Ports) || len(c.Networks) != len(b.Networks) {
 		return false
 	}
 	for name, val1 := range c.Labels {
@@ -38,6 +45,16 @@ func (c *Container) Equal(b *Container) bool {
 			return false
 		}
 	}
+	for n, port1 := range c.Ports {
+		if !port1.Equal(b.Ports[n]) {
+			return false
+		}
+	}
+	for n, network1 := range c.Networks {
+		if !network1.Equal(b.Networks[n]) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -54,10 +71,26 @@ func (c *Container) Copy() *Container {
 	for n, binding := range c.Bindings {
 		newBindings[n] = binding.Copy()
 	}
+	var newPorts []*Port
+	if c.Ports != nil {
+		newPorts = make([]*Port, len(c.Ports))
+		for n, port := range c.Ports {
+			newPorts[n] = port.Copy()
+		}
+	}
+	var newNetworks []*Network
+	if c.Networks != nil {
+		newNetworks = make([]*Network, len(c.Networks))
+		for n, network := range c.Networks {
+			newNetworks[n] = network.Copy()
+		}
+	}
 	return &Container{
 		ID:       c.ID,
 		Service:  c.Service,
 		Labels:   newLabels,
 		Bindings: newBindings,
+		Ports:    newPorts,
+		Networks: newNetworks,
 	}
 }
@@ -176,6 +176,32 @@ func TestContainerEqual(t *testing.T) {
 			},
 			Equal: false,
 		},
+		{
+			A: &beacon.Container{
+				ID:       "1234",
+				Service:  "www",
+				Ports:    []*beacon.Port{{ContainerPort: 80, Protocol: beacon.TCP}},
+				Networks: []*beacon.Network{{Name: "backend", IP: "172.18.0.2", Aliases: []string{"www"}}},
+			},
+			B: &beacon.Container{
+				ID:       "1234",
+				Service:  "www",
+				Ports:    []*beacon.Port{{ContainerPort: 80, Protocol: beacon.TCP}},
+				Networks: []*beacon.Network{{Name: "backend", IP: "172.18.0.2", Aliases: []string{"web"}}},
+			},
+			Equal: false,
+		},
+		{
+			A: &beacon.Container{
+				ID:    "1234",
+				Ports: []*beacon.Port{{ContainerPort: 80, Protocol: beacon.TCP}},
+			},
+			B: &beacon.Container{
+				ID:    "1234",
+				Ports: []*beacon.Port{{ContainerPort: 80, Protocol: beacon.UDP}},
+			},
+			Equal: false,
+		},
 	}
 
 	for n, test := range tests {
@@ -207,6 +233,20 @@ func TestContainerCopy(t *testing.T) {
 				Protocol:      beacon.TCP,
 			},
 		},
+		Ports: []*beacon.Port{
+			{
+				ContainerPort: 8080,
+				Protocol:      beacon.TCP,
+			},
+		},
+		Networks: []*beacon.Network{
+			{
+				Name:    "backend",
+				IP:      "172.18.0.2",
+				IPv6:    "fd00::2",
+				Aliases: []string{"example"},
+			},
+		},
 	}
 	newContainer := container.Copy()
 
@@ -231,4 +271,9 @@ func TestContainerCopy(t *testing.T) {
 	if reflect.DeepEqual(container.Bindings, newContainer.Bindings) {
 		t.Error("container.Bindings copy points to same memory space")
 	}
+
+	container.Networks[0].Aliases[0] = "www"
+	if reflect.DeepEqual(container.Networks, newContainer.Networks) {
+		t.Error("container.Networks copy points to same memory space")
+	}
 }
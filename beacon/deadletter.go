@@ -0,0 +1,129 @@
+package beacon
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter records an event which a queue gave up on delivering.
+type DeadLetter struct {
+	// The name of the route the event was queued for.
+	Route string
+
+	// The event which failed to be delivered.
+	Event *Event
+
+	// The error returned by the final delivery attempt.
+	Error string
+
+	// The number of delivery attempts made.
+	Attempts int
+
+	// When the queue gave up on the event.
+	Time time.Time
+}
+
+// DeadLetterSink receives events which a queue failed to deliver.
+type DeadLetterSink interface {
+	// DeadLetter records an undeliverable event.
+	DeadLetter(letter *DeadLetter) error
+
+	// Close frees any resources associated with the sink.
+	Close() error
+}
+
+// NewDeadLetterFile creates a sink which appends dead letters to the file at
+// `path` as lines of JSON. The file is created if it does not exist.
+func NewDeadLetterFile(path string) DeadLetterSink {
+	return &deadLetterFile{
+		path: path,
+		lock: &sync.Mutex{},
+	}
+}
+
+// NewDeadLetterBackend creates a sink which sends the event of each dead letter
+// to `backend`. The backend is closed with the sink.
+func NewDeadLetterBackend(backend Backend) DeadLetterSink {
+	return &deadLetterBackend{
+		backend: backend,
+	}
+}
+
+// ReadDeadLetters reads the dead letters from a file written by a sink created
+// with NewDeadLetterFile.
+func ReadDeadLetters(path string) ([]*DeadLetter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open dead letter file %s", path)
+	}
+	defer file.Close()
+
+	letters := []*DeadLetter{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		letter := &DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), letter); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse dead letter on line %d of %s", line, path)
+		}
+		letters = append(letters, letter)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read dead letter file %s", path)
+	}
+	return letters, nil
+}
+
+// deadLetterFile appends dead letters to a file.
+type deadLetterFile struct {
+	path string
+	lock *sync.Mutex
+}
+
+// DeadLetter appends the letter to the file.
+func (s *deadLetterFile) DeadLetter(letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize dead letter")
+	}
+	data = append(data, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open dead letter file %s", s.path)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "failed to write dead letter file %s", s.path)
+	}
+	return errors.Wrapf(file.Close(), "failed to close dead letter file %s", s.path)
+}
+
+// Close is a noop for files.
+func (s *deadLetterFile) Close() error {
+	return nil
+}
+
+// deadLetterBackend sends dead lettered events to a backend.
+type deadLetterBackend struct {
+	backend Backend
+}
+
+// DeadLetter sends the letter's event to the backend.
+func (s *deadLetterBackend) DeadLetter(letter *DeadLetter) error {
+	return s.backend.ProcessEvent(letter.Event)
+}
+
+// Close the backend.
+func (s *deadLetterBackend) Close() error {
+	return s.backend.Close()
+}
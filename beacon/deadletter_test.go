@@ -0,0 +1,157 @@
+package beacon_test
+
+import (
+	beacon "."
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterFile(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.jsonl")
+
+	backend := NewFailingBackend(5, beacon.Permanent(errors.New("bad request")))
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, backend), beacon.QueueConfig{
+		Name:       "test",
+		Retry:      beacon.RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+		DeadLetter: beacon.NewDeadLetterFile(path),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []*beacon.Event{QueueEvent("1"), QueueEvent("2")}
+	for _, event := range events {
+		if err := queue.ProcessEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	letters, err := beacon.ReadDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != len(events) {
+		t.Fatalf("read %d dead letters, want %d", len(letters), len(events))
+	}
+	for n, letter := range letters {
+		if letter.Route != "test" {
+			t.Errorf("letter %d: route %s != test", n, letter.Route)
+		}
+		if letter.Attempts != 1 {
+			t.Errorf("letter %d: attempts %d != 1", n, letter.Attempts)
+		}
+		if letter.Error == "" {
+			t.Errorf("letter %d: missing error", n)
+		}
+		if letter.Time.IsZero() {
+			t.Errorf("letter %d: missing time", n)
+		}
+		if err := EventsEqual(letter.Event, events[n]); err != nil {
+			t.Errorf("letter %d: %s", n, err)
+		}
+	}
+}
+
+// ReportingBackend accepts every event and fails them later through the
+// failure handler.
+type ReportingBackend struct {
+	*FailingBackend
+	Fail func(event *beacon.Event, err error, attempts int)
+}
+
+func (b *ReportingBackend) SetFailureHandler(retry beacon.RetryPolicy, handler func(event *beacon.Event, err error, attempts int)) {
+	b.Fail = handler
+}
+
+func TestDeadLetterReported(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.jsonl")
+
+	backend := &ReportingBackend{FailingBackend: NewFailingBackend(0, nil)}
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, backend), beacon.QueueConfig{
+		Name:       "test",
+		DeadLetter: beacon.NewDeadLetterFile(path),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.Fail == nil {
+		t.Fatal("queue did not set the failure handler")
+	}
+	event := QueueEvent("1")
+	backend.Fail(event, errors.New("rejected"), 3)
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	letters, err := beacon.ReadDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("read %d dead letters, want 1", len(letters))
+	}
+	if letter := letters[0]; letter.Route != "test" || letter.Attempts != 3 || letter.Error != "rejected" {
+		t.Errorf("have letter %+v", letter)
+	}
+	if err := EventsEqual(letters[0].Event, event); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeadLetterBackend(t *testing.T) {
+	t.Parallel()
+	deadBackend := NewFailingBackend(0, nil)
+	backend := NewFailingBackend(5, errors.New("transient"))
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, backend), beacon.QueueConfig{
+		Name:       "test",
+		Retry:      beacon.RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+		DeadLetter: beacon.NewDeadLetterBackend(deadBackend),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := QueueEvent("1")
+	if err := queue.ProcessEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := backend.Calls(); calls != 2 {
+		t.Errorf("backend called %d times, want 2", calls)
+	}
+	if calls := deadBackend.Calls(); calls != 1 {
+		t.Fatalf("dead letter backend called %d times, want 1", calls)
+	}
+	if err := EventsEqual(<-deadBackend.Attempts, event); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadDeadLettersMissing(t *testing.T) {
+	t.Parallel()
+	if _, err := beacon.ReadDeadLetters("/nonexistent/dead.jsonl"); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
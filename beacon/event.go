@@ -8,9 +8,18 @@ const (
 	Start  Action = "start"  // Container started.
 	Stop          = "stop"   // Container stopped.
 	Update        = "update" // Container updated.
+	OOM           = "oom"    // Container ran out of memory and may be about to stop.
+	Synced        = "synced" // Runtime has sent a Start for every running container.
 )
 
 // Event indicates when the status of a container changes.
+//
+// A runtime sends a Synced event once it has sent a Start event for each
+// container it found running when it started. Synced events have no container
+// and are not sent to backends.
+//
+// An OOM event warns backends that a container may be about to stop. It does
+// not change the container, which is stopped by a later Stop event if it dies.
 type Event struct {
 	// The action that triggered this event.
 	Action Action
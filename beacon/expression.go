@@ -0,0 +1,623 @@
+package beacon
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FilterError describes a syntax error in a filter expression.
+type FilterError struct {
+	Pattern string // The filter expression.
+	Pos     int    // The byte offset in the expression where the error occurred.
+	Msg     string // A description of the error.
+}
+
+// Error formats the error with its position.
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter at position %d: %s: %s", e.Pos, e.Msg, e.Pattern)
+}
+
+// exprFilter matches containers and events against a parsed filter expression.
+type exprFilter struct {
+	root node
+}
+
+// MatchContainer returns true if the container matches the expression. Action
+// predicates never match a container on its own. Routes match events with
+// MatchEvent.
+func (f *exprFilter) MatchContainer(c *Container) bool {
+	return f.root.match(&Event{Container: c})
+}
+
+// MatchEvent returns true if the event matches the expression.
+func (f *exprFilter) MatchEvent(e *Event) bool {
+	return f.root.match(e)
+}
+
+// node is an element of a parsed filter expression.
+type node interface {
+	match(e *Event) bool
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) match(e *Event) bool {
+	return n.left.match(e) && n.right.match(e)
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) match(e *Event) bool {
+	return n.left.match(e) || n.right.match(e)
+}
+
+type notNode struct {
+	node node
+}
+
+func (n *notNode) match(e *Event) bool {
+	return !n.node.match(e)
+}
+
+// existsNode matches containers which have a label.
+type existsNode struct {
+	label string
+}
+
+func (n *existsNode) match(e *Event) bool {
+	if e.Container == nil {
+		return false
+	}
+	_, ok := e.Container.Labels[n.label]
+	return ok
+}
+
+// valueNode matches a string field of the event using a test function.
+type valueNode struct {
+	field func(e *Event) (string, bool)
+	test  func(value string) bool
+}
+
+func (n *valueNode) match(e *Event) bool {
+	value, ok := n.field(e)
+	return ok && n.test(value)
+}
+
+// portNode matches events whose container binds or exposes one of the ports.
+type portNode struct {
+	ports []portValue
+}
+
+func (n *portNode) match(e *Event) bool {
+	if e.Container == nil {
+		return false
+	}
+	for _, port := range n.ports {
+		for _, binding := range e.Container.Bindings {
+			if port.matches(binding.ContainerPort, binding.Protocol) {
+				return true
+			}
+		}
+		for _, exposed := range e.Container.Ports {
+			if port.matches(exposed.ContainerPort, exposed.Protocol) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// portValue is a container port with an optional protocol.
+type portValue struct {
+	number   int
+	protocol Protocol
+}
+
+// matches returns true if the container port and protocol match the value.
+func (v portValue) matches(number int, protocol Protocol) bool {
+	return number == v.number && (v.protocol == "" || protocol == v.protocol)
+}
+
+// Token types produced by the lexer.
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokWord
+	tokString
+	tokLParen
+	tokRParen
+	tokComma
+	tokNot
+	tokAnd
+	tokOr
+	tokEq
+	tokNe
+	tokMatch
+	tokNotMatch
+)
+
+// token is a lexical element of a filter expression.
+type token struct {
+	typ   tokenType
+	value string
+	pos   int
+}
+
+// lex splits a filter expression into tokens.
+func lex(pattern string) ([]token, error) {
+	tokens := []token{}
+	for pos := 0; pos < len(pattern); {
+		c := pattern[pos]
+		next := byte(0)
+		if pos+1 < len(pattern) {
+			next = pattern[pos+1]
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", pos})
+			pos++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", pos})
+			pos++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", pos})
+			pos++
+		case c == '&' && next == '&':
+			tokens = append(tokens, token{tokAnd, "&&", pos})
+			pos += 2
+		case c == '|' && next == '|':
+			tokens = append(tokens, token{tokOr, "||", pos})
+			pos += 2
+		case c == '=' && next == '=':
+			tokens = append(tokens, token{tokEq, "==", pos})
+			pos += 2
+		case c == '=' && next == '~':
+			tokens = append(tokens, token{tokMatch, "=~", pos})
+			pos += 2
+		case c == '=':
+			tokens = append(tokens, token{tokEq, "=", pos})
+			pos++
+		case c == '!' && next == '=':
+			tokens = append(tokens, token{tokNe, "!=", pos})
+			pos += 2
+		case c == '!' && next == '~':
+			tokens = append(tokens, token{tokNotMatch, "!~", pos})
+			pos += 2
+		case c == '!':
+			tokens = append(tokens, token{tokNot, "!", pos})
+			pos++
+		case c == '\'':
+			end := strings.IndexByte(pattern[pos+1:], c)
+			if end < 0 {
+				return nil, &FilterError{pattern, pos, "unterminated string"}
+			}
+			tokens = append(tokens, token{tokString, pattern[pos+1 : pos+1+end], pos})
+			pos += end + 2
+		case c == '"':
+			end := pos + 1
+			for ; end < len(pattern) && pattern[end] != c; end++ {
+				if pattern[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(pattern) {
+				return nil, &FilterError{pattern, pos, "unterminated string"}
+			}
+			value, err := strconv.Unquote(pattern[pos : end+1])
+			if err != nil {
+				return nil, &FilterError{pattern, pos, "invalid string"}
+			}
+			tokens = append(tokens, token{tokString, value, pos})
+			pos = end + 1
+		case isWordByte(c):
+			end := pos
+			for end < len(pattern) && isWordByte(pattern[end]) {
+				end++
+			}
+			tokens = append(tokens, token{tokWord, pattern[pos:end], pos})
+			pos = end
+		default:
+			return nil, &FilterError{pattern, pos, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(pattern)})
+	return tokens, nil
+}
+
+// isWordByte returns true if the byte may appear in an unquoted word.
+func isWordByte(c byte) bool {
+	return c >= 0x80 || !strings.ContainsRune(" \t\n\r()!=,&|~\"'", rune(c))
+}
+
+// parser builds an expression tree from tokens.
+type parser struct {
+	pattern string
+	tokens  []token
+	pos     int
+	actions bool // whether the action field is allowed
+}
+
+// parseExpression parses a filter expression. Action predicates are rejected
+// unless `actions` is true.
+func parseExpression(pattern string, actions bool) (Filter, error) {
+	tokens, err := lex(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{pattern: pattern, tokens: tokens, actions: actions}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.typ != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", describe(tok))
+	}
+	return &exprFilter{root: root}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.typ != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// keyword returns true if the next token is the given keyword.
+func (p *parser) keyword(word string) bool {
+	tok := p.peek()
+	return tok.typ == tokWord && strings.EqualFold(tok.value, word)
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &FilterError{p.pattern, tok.pos, fmt.Sprintf(format, args...)}
+}
+
+// describe a token for use in error messages.
+func describe(tok token) string {
+	switch tok.typ {
+	case tokEOF:
+		return "end of filter"
+	case tokString:
+		return fmt.Sprintf("string %q", tok.value)
+	}
+	return fmt.Sprintf("%q", tok.value)
+}
+
+// parseOr parses: and { ("||" | "or") and }
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokOr || p.keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary { ("&&" | "and" | ",") unary }
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokAnd || p.peek().typ == tokComma || p.keyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: ("!" | "not") unary | "(" or ")" | predicate
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().typ == tokNot || p.keyword("not") {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	}
+	if p.peek().typ == tokLParen {
+		open := p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.typ != tokRParen {
+			return nil, p.errorf(tok, "expected ) to close ( at position %d, found %s", open.pos, describe(tok))
+		}
+		return n, nil
+	}
+	return p.parsePredicate()
+}
+
+// parsePredicate parses a comparison against a field or label. Fields are
+// prefixed with '@'. A label on its own tests for the label's existence.
+// Quoted names are always labels.
+func (p *parser) parsePredicate() (node, error) {
+	tok := p.next()
+	if tok.typ != tokWord && tok.typ != tokString {
+		return nil, p.errorf(tok, "expected label or field, found %s", describe(tok))
+	}
+
+	if tok.typ == tokWord && strings.HasPrefix(tok.value, "@") {
+		switch field := strings.ToLower(tok.value[1:]); field {
+		case "port":
+			return p.parsePort(tok)
+		case "action":
+			if !p.actions {
+				return nil, p.errorf(tok, "%s cannot match a container", tok.value)
+			}
+			return p.parseValue(tok, fieldGetter(field, ""))
+		case "service", "id":
+			return p.parseValue(tok, fieldGetter(field, ""))
+		}
+		return nil, p.errorf(tok, "unknown field %s", tok.value)
+	}
+
+	if !p.atOperator() {
+		return &existsNode{tok.value}, nil
+	}
+	return p.parseValue(tok, fieldGetter("", tok.value))
+}
+
+// atOperator returns true if the next token is a comparison operator.
+func (p *parser) atOperator() bool {
+	switch p.peek().typ {
+	case tokEq, tokNe, tokMatch, tokNotMatch:
+		return true
+	}
+	return p.keyword("in") || p.keyword("like") || (p.keyword("not") && p.pos+1 < len(p.tokens) &&
+		p.tokens[p.pos+1].typ == tokWord && (strings.EqualFold(p.tokens[p.pos+1].value, "in") || strings.EqualFold(p.tokens[p.pos+1].value, "like")))
+}
+
+// fieldGetter returns a function which reads a field or label from an event.
+func fieldGetter(field, label string) func(e *Event) (string, bool) {
+	switch field {
+	case "action":
+		return func(e *Event) (string, bool) {
+			return string(e.Action), e.Action != ""
+		}
+	case "service":
+		return func(e *Event) (string, bool) {
+			if e.Container == nil {
+				return "", false
+			}
+			return e.Container.Service, true
+		}
+	case "id":
+		return func(e *Event) (string, bool) {
+			if e.Container == nil {
+				return "", false
+			}
+			return e.Container.ID, true
+		}
+	}
+	return func(e *Event) (string, bool) {
+		if e.Container == nil {
+			return "", false
+		}
+		value, ok := e.Container.Labels[label]
+		return value, ok
+	}
+}
+
+// parseValue parses the operator and value(s) which follow a string field.
+func (p *parser) parseValue(fieldTok token, field func(e *Event) (string, bool)) (node, error) {
+	negate := false
+	if p.keyword("not") {
+		p.next()
+		negate = true
+	}
+
+	op := p.next()
+	var n node
+	switch {
+	case op.typ == tokEq || op.typ == tokNe:
+		value := ""
+		if op.value != "=" || !p.atEnd() {
+			var err error
+			if value, err = p.parseOperand(); err != nil {
+				return nil, err
+			}
+		}
+		n = &valueNode{field, func(v string) bool { return v == value }}
+		if op.typ == tokNe {
+			negate = true
+		}
+	case op.typ == tokMatch || op.typ == tokNotMatch:
+		valueTok := p.peek()
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, p.errorf(valueTok, "invalid regular expression: %s", err)
+		}
+		n = &valueNode{field, re.MatchString}
+		if op.typ == tokNotMatch {
+			negate = true
+		}
+	case op.typ == tokWord && strings.EqualFold(op.value, "like"):
+		valueTok := p.peek()
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		re, err := compileGlob(value)
+		if err != nil {
+			return nil, p.errorf(valueTok, "invalid glob: %s", err)
+		}
+		n = &valueNode{field, re.MatchString}
+	case op.typ == tokWord && strings.EqualFold(op.value, "in"):
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		n = &valueNode{field, func(v string) bool {
+			for _, value := range values {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		}}
+	default:
+		return nil, p.errorf(op, "expected operator after %s, found %s", describe(fieldTok), describe(op))
+	}
+
+	if negate {
+		n = &notNode{n}
+	}
+	return n, nil
+}
+
+// parsePort parses the operator and port(s) which follow the port field.
+func (p *parser) parsePort(fieldTok token) (node, error) {
+	negate := false
+	if p.keyword("not") {
+		p.next()
+		negate = true
+	}
+
+	var values []string
+	op := p.peek()
+	switch {
+	case op.typ == tokEq || op.typ == tokNe:
+		p.next()
+		valueTok := p.peek()
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		values = []string{value}
+		if op.typ == tokNe {
+			negate = true
+		}
+		op = valueTok
+	case op.typ == tokWord && strings.EqualFold(op.value, "in"):
+		p.next()
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		values = list
+	default:
+		return nil, p.errorf(op, "expected ==, != or in after %s, found %s", fieldTok.value, describe(op))
+	}
+
+	ports := make([]portValue, len(values))
+	for n, value := range values {
+		port, err := parsePortValue(value)
+		if err != nil {
+			return nil, p.errorf(op, "%s", err)
+		}
+		ports[n] = port
+	}
+
+	var n node = &portNode{ports}
+	if negate {
+		n = &notNode{n}
+	}
+	return n, nil
+}
+
+// parseOperand parses a single word or string value.
+func (p *parser) parseOperand() (string, error) {
+	tok := p.next()
+	if tok.typ != tokWord && tok.typ != tokString {
+		return "", p.errorf(tok, "expected value, found %s", describe(tok))
+	}
+	return tok.value, nil
+}
+
+// atEnd returns true if the next token ends a predicate. A missing value
+// after '=', as in 'label=,other=value', is an empty value.
+func (p *parser) atEnd() bool {
+	switch p.peek().typ {
+	case tokEOF, tokComma, tokRParen, tokAnd, tokOr:
+		return true
+	}
+	return false
+}
+
+// parseList parses a parenthesized, comma separated list of values.
+func (p *parser) parseList() ([]string, error) {
+	if tok := p.next(); tok.typ != tokLParen {
+		return nil, p.errorf(tok, "expected ( to start list, found %s", describe(tok))
+	}
+	values := []string{}
+	for {
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		tok := p.next()
+		if tok.typ == tokRParen {
+			return values, nil
+		} else if tok.typ != tokComma {
+			return nil, p.errorf(tok, "expected , or ) in list, found %s", describe(tok))
+		}
+	}
+}
+
+// parsePortValue parses a port of the form "80" or "80/tcp".
+func parsePortValue(value string) (portValue, error) {
+	port := portValue{}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) > 1 {
+		switch Protocol(strings.ToLower(parts[1])) {
+		case TCP:
+			port.protocol = TCP
+		case UDP:
+			port.protocol = UDP
+		default:
+			return port, errors.Errorf("unsupported protocol %s", parts[1])
+		}
+	}
+	number, err := strconv.Atoi(parts[0])
+	if err != nil || number < 0 || number > 65535 {
+		return port, errors.Errorf("invalid port %s", value)
+	}
+	port.number = number
+	return port, nil
+}
+
+// compileGlob converts a glob pattern into a regular expression. The glob
+// supports * to match any sequence of characters and ? to match any single
+// character.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return regexp.Compile("^" + expr + "$")
+}
@@ -0,0 +1,201 @@
+package beacon_test
+
+import (
+	beacon "."
+	"testing"
+)
+
+// ExpressionEvent is the event used to test filter expressions.
+var ExpressionEvent = &beacon.Event{
+	Action: beacon.Stop,
+	Container: &beacon.Container{
+		ID:      "123456",
+		Service: "www",
+		Labels: map[string]string{
+			"group":   "ops",
+			"env":     "prod-east",
+			"service": "www",
+			"version": "1.2.3",
+			"desc":    "web server",
+			"empty":   "",
+			"port":    "http",
+			"not":     "yes",
+			"and":     "yes",
+			"url":     "a=b!~c&d|e'f\"",
+		},
+		Bindings: []*beacon.Binding{
+			{HostIP: "127.0.0.1", HostPort: 56291, ContainerPort: 80, Protocol: beacon.TCP},
+			{HostIP: "127.0.0.1", HostPort: 56292, ContainerPort: 53, Protocol: beacon.UDP},
+		},
+		Ports: []*beacon.Port{
+			{ContainerPort: 9090, Protocol: beacon.TCP},
+		},
+	},
+}
+
+func TestParseFilterMatch(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		Pattern string
+		Match   bool
+	}{
+		{"group=ops", true},
+		{"group=ops,env=prod-east", true},
+		{"group=ops,env=dev", false},
+		{"group == ops && env != dev", true},
+		{"group == ops and not env == prod-east", false},
+		{"group = dev or env = prod-east", true},
+		{"group = dev || env = dev", false},
+		{"!(group = dev || env = dev)", true},
+		{"(group = dev or group = ops) and env = prod-east", true},
+		{"group", true},
+		{"missing", false},
+		{"!missing", true},
+		{"missing != value", true},
+		{"env like 'prod-*'", true},
+		{"env like prod-????", true},
+		{"env like dev-*", false},
+		{"env not like dev-*", true},
+		{`version =~ "^1\\.2\\."`, true},
+		{`version !~ "^2\\."`, true},
+		{"version =~ ^2", false},
+		{"env in (dev, prod-east)", true},
+		{"env not in (dev, test)", true},
+		{`desc == "web server"`, true},
+		{"@service == www", true},
+		{"@service in (api, db)", false},
+		{`"@service" == www`, false},
+		{"@id == 123456", true},
+		{"@action == stop", true},
+		{"@action in (start, stop)", true},
+		{"@action in (start, update)", false},
+		{"@port == 80/tcp", true},
+		{"@port == 80/udp", false},
+		{"@port == 53", true},
+		{"@port != 443", true},
+		{"@port in (443/tcp, 53/udp)", true},
+		{"@port not in (80, 53)", false},
+		{"@port == 9090/tcp", true},
+		{"@port == 9090/udp", false},
+		{"empty=", true},
+		{"empty=,group=ops", true},
+		{"group=", false},
+		{"(empty = ) && group == ops", true},
+		{"GROUP == ops", false},
+		{"group == ops AND @Service == www", true},
+		{"service=www", true},
+		{"Service=www", false},
+		{"port=http", true},
+		{"port=80", false},
+		{"id=123456", false},
+		{"not=yes", true},
+		{"and=yes,group=ops", true},
+		{"not=no,group=ops", false},
+		{`url=a=b!~c&d|e'f"`, true},
+		{"group=ops=", false},
+	}
+
+	for _, test := range tests {
+		filter, err := beacon.ParseFilter(test.Pattern)
+		if err != nil {
+			t.Errorf("%s: %s", test.Pattern, err)
+			continue
+		}
+		matcher, ok := filter.(interface {
+			MatchEvent(*beacon.Event) bool
+		})
+		if !ok {
+			t.Fatalf("%s: filter does not match events", test.Pattern)
+		}
+		if have := matcher.MatchEvent(ExpressionEvent); have != test.Match {
+			t.Errorf("%s: match is %t, want %t", test.Pattern, have, test.Match)
+		}
+	}
+}
+
+func TestParseFilterContainer(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		Pattern string
+		Match   bool
+	}{
+		{"", true},
+		{"group=ops", true},
+		{"@action == stop", false},
+		{"@action != stop", true},
+		{"group=ops and @action in (start, stop)", false},
+	}
+
+	for _, test := range tests {
+		filter, err := beacon.ParseFilter(test.Pattern)
+		if err != nil {
+			t.Errorf("%s: %s", test.Pattern, err)
+			continue
+		}
+		if have := filter.MatchContainer(ExpressionEvent.Container); have != test.Match {
+			t.Errorf("%s: match is %t, want %t", test.Pattern, have, test.Match)
+		}
+	}
+}
+
+func TestParseFilterError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		Pattern string
+		Pos     int
+	}{
+		{"group", -1},
+		{"group=ops=,env", 9},
+		{"group ==", 8},
+		{"group != ,env=dev", 9},
+		{"group==ops &&", 13},
+		{"(group == ops", 13},
+		{"group == ops)", 12},
+		{"group ~ ops", 6},
+		{`group == "ops`, 9},
+		{"env in dev", 7},
+		{"env in (dev test)", 12},
+		{"version =~ '('", 11},
+		{"@port == http", 9},
+		{"@port == 80/sctp", 9},
+		{"@port =~ 80", 6},
+		{"@service", 8},
+		{"@action", 7},
+		{"@name == www", 0},
+		{"group == ops,env", -1},
+		{"group == (ops)", 9},
+		{"group == ops env == dev", 13},
+	}
+
+	for _, test := range tests {
+		_, err := beacon.ParseFilter(test.Pattern)
+		if test.Pos < 0 {
+			if err != nil {
+				t.Errorf("%s: %s", test.Pattern, err)
+			}
+			continue
+		}
+		filterErr, ok := err.(*beacon.FilterError)
+		if !ok {
+			t.Errorf("%s: expected *FilterError, got %v", test.Pattern, err)
+		} else if filterErr.Pos != test.Pos {
+			t.Errorf("%s: error at position %d, want %d: %s", test.Pattern, filterErr.Pos, test.Pos, err)
+		}
+	}
+}
+
+func TestParseContainerFilter(t *testing.T) {
+	t.Parallel()
+	if _, err := beacon.ParseContainerFilter("group == ops and @service == www"); err != nil {
+		t.Error(err)
+	}
+	if _, err := beacon.ParseContainerFilter("action=stop"); err != nil {
+		t.Error(err)
+	}
+	_, err := beacon.ParseContainerFilter("group == ops and @action in (start, stop)")
+	if filterErr, ok := err.(*beacon.FilterError); !ok {
+		t.Errorf("expected *FilterError, got %v", err)
+	} else if filterErr.Pos != 17 {
+		t.Errorf("error at position %d, want 17: %s", filterErr.Pos, err)
+	}
+}
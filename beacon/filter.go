@@ -1,7 +1,7 @@
 package beacon
 
 import (
-	"github.com/pkg/errors"
+	"regexp"
 	"strings"
 )
 
@@ -16,24 +16,100 @@ func NewFilter(labels map[string]string) Filter {
 	}
 }
 
-// ParseFilter creates a filter from the provided pattern. The pattern has the
-// form 'label1=value1,label2=value2,...'. The container must match all of the
-// lable/value pairs. Only matching against labels is currently supported.
+// ParseFilter creates a filter from the provided expression. An empty
+// expression matches everything.
+//
+// The simplest expression has the form 'label1=value1,label2=value2,...' and
+// matches containers which have all of the label/value pairs. A missing value,
+// as in 'label=', matches a label with an empty value. Expressions may
+// also combine predicates with 'and' (or '&&' or ','), 'or' (or '||'), 'not'
+// (or '!'), and parentheses. The following predicates are supported:
+//
+//	label               the container has the label
+//	label == value      the label equals the value ('=' is equivalent)
+//	label != value      the label does not equal the value
+//	label =~ regex      the label matches the regular expression
+//	label !~ regex      the label does not match the regular expression
+//	label like glob     the label matches a glob using '*' and '?'
+//	label in (a, b)     the label equals one of the values
+//	@service == value   the container's service equals the value
+//	@id == value        the container's ID equals the value
+//	@action in (a, b)   the event's action is one of the values
+//	@port == 80/tcp     the container binds or exposes the port
+//
+// The @service, @id, and @action fields support the same operators as labels.
+// The @port field supports '==', '!=' and 'in' and the protocol is optional.
+// 'in' and 'like' may be negated with 'not', e.g. 'env not in (dev, test)'.
+// Values and labels which contain spaces or operator characters may be quoted
+// with double or single quotes. Action predicates do not match when the
+// filter is applied to a container with MatchContainer rather than to an
+// event with MatchEvent.
+//
+// A pattern which is not a valid expression but has the form
+// 'label1=value1,label2=value2,...' is parsed as it was before expressions
+// were supported: each label is compared to all of the text after its first
+// '='. This keeps filters such as 'not=yes' and 'url=a=b' working.
+//
+// An error of type *FilterError is returned if the expression is invalid.
 func ParseFilter(pattern string) (Filter, error) {
-	if pattern == "" {
+	return parseFilter(pattern, true)
+}
+
+// ParseContainerFilter works like ParseFilter but rejects expressions with
+// action predicates, which never match a container on its own.
+func ParseContainerFilter(pattern string) (Filter, error) {
+	return parseFilter(pattern, false)
+}
+
+// parseFilter parses an expression, falling back to label/value pairs.
+func parseFilter(pattern string, actions bool) (Filter, error) {
+	if strings.TrimSpace(pattern) == "" {
 		return &labelFilter{}, nil
 	}
+	filter, err := parseExpression(pattern, actions)
+	if err != nil {
+		if labels, ok := parseLabels(pattern); ok {
+			return &labelFilter{labels: labels}, nil
+		}
+		return nil, err
+	}
+	return filter, nil
+}
+
+// labelPair matches a 'label=value' pair where the label is a plain word and
+// the '=' is not part of a '==' or '=~' operator.
+var labelPair = regexp.MustCompile(`^[^\s()!=,&|~"']+=([^=~]|$)`)
+
+// parseLabels parses a pattern of the form 'label1=value1,label2=value2,...'.
+// It returns false if any pair is not of that form.
+func parseLabels(pattern string) (map[string]string, bool) {
 	pairs := strings.Split(pattern, ",")
 	labels := make(map[string]string, len(pairs))
 	for _, pair := range pairs {
-		parts := strings.SplitN(pair, "=", 2)
-		if len(parts) > 1 {
-			labels[parts[0]] = parts[1]
-		} else {
-			return nil, errors.Errorf("invalid filter pattern: %s", pattern)
+		if !labelPair.MatchString(pair) {
+			return nil, false
 		}
+		parts := strings.SplitN(pair, "=", 2)
+		labels[parts[0]] = parts[1]
+	}
+	return labels, true
+}
+
+// NewActionFilter creates a filter which matches events that have one of the
+// given actions and whose container matches `filter`. Events with any action
+// match if `actions` is empty. Containers are matched against `filter` alone.
+func NewActionFilter(filter Filter, actions []Action) Filter {
+	if filter == nil {
+		filter = &allFilter{}
+	}
+	actionSet := make(map[Action]struct{}, len(actions))
+	for _, action := range actions {
+		actionSet[action] = struct{}{}
+	}
+	return &actionFilter{
+		filter:  filter,
+		actions: actionSet,
 	}
-	return &labelFilter{labels: labels}, nil
 }
 
 // Filter is used to match containers againston a set of criteria.
@@ -41,6 +117,21 @@ type Filter interface {
 	MatchContainer(*Container) bool
 }
 
+// EventFilter is used to match events against a set of criteria. The filters
+// in this package implement both Filter and EventFilter.
+type EventFilter interface {
+	MatchEvent(*Event) bool
+}
+
+// matchEvent matches an event against a filter. Filters which do not
+// implement EventFilter are matched against the event's container.
+func matchEvent(filter Filter, e *Event) bool {
+	if eventFilter, ok := filter.(EventFilter); ok {
+		return eventFilter.MatchEvent(e)
+	}
+	return e.Container != nil && filter.MatchContainer(e.Container)
+}
+
 // Basic filter which checks that the container has all of the given label values.
 type labelFilter struct {
 	labels map[string]string
@@ -55,6 +146,11 @@ func (f *labelFilter) MatchContainer(c *Container) bool {
 	return true
 }
 
+// MatchEvent matches the event's container.
+func (f *labelFilter) MatchEvent(e *Event) bool {
+	return e.Container != nil && f.MatchContainer(e.Container)
+}
+
 // A filter that matches everything.
 type allFilter struct{}
 
@@ -62,3 +158,30 @@ type allFilter struct{}
 func (*allFilter) MatchContainer(*Container) bool {
 	return true
 }
+
+// MatchEvent returns true.
+func (*allFilter) MatchEvent(*Event) bool {
+	return true
+}
+
+// A filter which matches events by action in addition to another filter.
+type actionFilter struct {
+	filter  Filter
+	actions map[Action]struct{}
+}
+
+// MatchContainer matches the container against the wrapped filter.
+func (f *actionFilter) MatchContainer(c *Container) bool {
+	return f.filter.MatchContainer(c)
+}
+
+// MatchEvent returns true if the event has one of the filter's actions and
+// matches the wrapped filter.
+func (f *actionFilter) MatchEvent(e *Event) bool {
+	if len(f.actions) > 0 {
+		if _, ok := f.actions[e.Action]; !ok {
+			return false
+		}
+	}
+	return matchEvent(f.filter, e)
+}
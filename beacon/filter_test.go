@@ -19,3 +19,48 @@ func TestFilterEmpty(t *testing.T) {
 		}
 	}
 }
+
+func TestActionFilter(t *testing.T) {
+	labels, err := beacon.ParseFilter("a=aye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := beacon.NewActionFilter(labels, []beacon.Action{beacon.Stop})
+	ef, ok := f.(beacon.EventFilter)
+	if !ok {
+		t.Fatal("action filter does not implement EventFilter")
+	}
+
+	container := &beacon.Container{Labels: map[string]string{"a": "aye"}}
+	other := &beacon.Container{Labels: map[string]string{"a": "eh"}}
+	tests := []struct {
+		Event *beacon.Event
+		Match bool
+	}{
+		{&beacon.Event{Action: beacon.Stop, Container: container}, true},
+		{&beacon.Event{Action: beacon.Start, Container: container}, false},
+		{&beacon.Event{Action: beacon.Update, Container: container}, false},
+		{&beacon.Event{Action: beacon.Stop, Container: other}, false},
+	}
+	for n, test := range tests {
+		if have := ef.MatchEvent(test.Event); have != test.Match {
+			t.Errorf("event %d: match is %t, want %t", n, have, test.Match)
+		}
+	}
+
+	if !f.MatchContainer(container) {
+		t.Error("container did not match")
+	}
+	if f.MatchContainer(other) {
+		t.Error("other container matched")
+	}
+}
+
+func TestActionFilterEmpty(t *testing.T) {
+	f := beacon.NewActionFilter(nil, nil).(beacon.EventFilter)
+	for _, action := range []beacon.Action{beacon.Start, beacon.Stop, beacon.Update} {
+		if !f.MatchEvent(&beacon.Event{Action: action, Container: &beacon.Container{}}) {
+			t.Errorf("action %s did not match", action)
+		}
+	}
+}
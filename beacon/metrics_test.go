@@ -0,0 +1,146 @@
+package beacon_test
+
+import (
+	beacon "."
+	"bufio"
+	"github.com/BlueDragonX/beacon/metrics"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Scrape fetches the metrics from a test server and returns the value of each
+// sample keyed by its name and labels.
+func Scrape(t *testing.T) map[string]float64 {
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	samples := map[string]float64{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[n+1:], 64)
+		if err != nil {
+			t.Fatalf("invalid sample %q", line)
+		}
+		samples[line[:n]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	before := Scrape(t)
+	retry := beacon.RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	route, err := beacon.NewQueue(
+		beacon.NewRoute(nil, NewFailingBackend(1, errors.New("transient"))),
+		beacon.QueueConfig{Name: "metrics-ok", Retry: retry},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failRoute, err := beacon.NewQueue(
+		beacon.NewRoute(nil, NewFailingBackend(1, beacon.Permanent(errors.New("rejected")))),
+		beacon.QueueConfig{Name: "metrics-fail", Retry: retry},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := NewRuntime()
+	bcn, err := beacon.New(runtime, []beacon.Route{route, failRoute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bcn.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	runtime.Events <- QueueEvent("1")
+	WaitForRoutes(t, bcn, []*beacon.RouteStats{
+		{Name: "metrics-ok", Routed: 1, Delivered: 1, Retried: 1},
+		{Name: "metrics-fail", Routed: 1, Failed: 1},
+	}, 5*time.Second)
+
+	samples := Scrape(t)
+	want := map[string]float64{
+		`beacon_route_events_total{route="metrics-ok"}`:                        1,
+		`beacon_route_deliveries_total{result="success",route="metrics-ok"}`:   1,
+		`beacon_route_deliveries_total{result="failure",route="metrics-ok"}`:   0,
+		`beacon_route_retries_total{route="metrics-ok"}`:                       1,
+		`beacon_route_dropped_total{route="metrics-ok"}`:                       0,
+		`beacon_route_queue_depth{route="metrics-ok"}`:                         0,
+		`beacon_route_delivery_seconds_count{route="metrics-ok"}`:              2,
+		`beacon_route_events_total{route="metrics-fail"}`:                      1,
+		`beacon_route_deliveries_total{result="success",route="metrics-fail"}`: 0,
+		`beacon_route_deliveries_total{result="failure",route="metrics-fail"}`: 1,
+		`beacon_route_delivery_seconds_count{route="metrics-fail"}`:            1,
+	}
+	for name, value := range want {
+		if _, ok := samples[name]; !ok {
+			t.Errorf("%s is missing", name)
+		} else if delta := samples[name] - before[name]; delta != value {
+			t.Errorf("%s increased by %v, want %v", name, delta, value)
+		}
+	}
+	for _, name := range []string{`beacon_events_received_total{action="start"}`, "beacon_containers"} {
+		if _, ok := samples[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+
+	if err := bcn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+func TestMetricsRemoveRoute(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	bcn, _, stop := runBeacon(t, runtime)
+	defer stop()
+
+	route, err := beacon.NewQueue(beacon.NewRoute(nil, NewBackend()), beacon.QueueConfig{Name: "metrics-removed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bcn.AddRoute(route); err != nil {
+		t.Fatal(err)
+	}
+	name := `beacon_route_queue_depth{route="metrics-removed"}`
+	if _, ok := Scrape(t)[name]; !ok {
+		t.Fatalf("%s is missing", name)
+	}
+
+	if err := bcn.RemoveRoute("metrics-removed"); err != nil {
+		t.Fatal(err)
+	}
+	for sample := range Scrape(t) {
+		if strings.Contains(sample, `route="metrics-removed"`) {
+			t.Errorf("%s is still reported", sample)
+		}
+	}
+}
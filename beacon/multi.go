@@ -0,0 +1,110 @@
+package beacon
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NewMultiRuntime creates a runtime which merges the events of several named
+// runtimes. Container IDs are prefixed with the name of their runtime and a
+// slash, e.g. `docker-0/512b64138152`, so that IDs from different runtimes do
+// not collide. A Synced event is sent once every runtime has synced.
+//
+// Closing the multi runtime closes each of its runtimes. Its event channel is
+// closed once every runtime's channel is closed.
+func NewMultiRuntime(runtimes map[string]Runtime) (Runtime, error) {
+	if len(runtimes) == 0 {
+		return nil, errors.New("runtimes cannot be empty")
+	}
+	names := make([]string, 0, len(runtimes))
+	for name, runtime := range runtimes {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, errors.Errorf("invalid runtime name %q", name)
+		} else if runtime == nil {
+			return nil, errors.Errorf("runtime %s cannot be nil", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &multiRuntime{
+		names:    names,
+		runtimes: runtimes,
+		wg:       &sync.WaitGroup{},
+	}, nil
+}
+
+// multiRuntime fans in events from several runtimes.
+type multiRuntime struct {
+	names    []string
+	runtimes map[string]Runtime
+	wg       *sync.WaitGroup
+}
+
+// EmitEvents starts each runtime and merges their events.
+func (m *multiRuntime) EmitEvents() (<-chan *Event, error) {
+	sources := make(map[string]<-chan *Event, len(m.names))
+	for _, name := range m.names {
+		events, err := m.runtimes[name].EmitEvents()
+		if err != nil {
+			// drain the started runtimes so that they may be closed
+			for _, events := range sources {
+				go func(events <-chan *Event) {
+					for range events {
+					}
+				}(events)
+			}
+			return nil, errors.Wrapf(err, "failed to start runtime %s", name)
+		}
+		sources[name] = events
+	}
+
+	merged := make(chan *Event, 1)
+	lock := &sync.Mutex{}
+	unsynced := len(sources)
+	for name, events := range sources {
+		m.wg.Add(1)
+		go func(name string, events <-chan *Event) {
+			defer m.wg.Done()
+			synced := false
+			for event := range events {
+				if event.Action == Synced {
+					// forward a single Synced once all runtimes have synced
+					if synced {
+						continue
+					}
+					synced = true
+					lock.Lock()
+					unsynced--
+					last := unsynced == 0
+					lock.Unlock()
+					if !last {
+						continue
+					}
+				} else if event.Container != nil {
+					event = event.Copy()
+					event.Container.ID = name + "/" + event.Container.ID
+				}
+				merged <- event
+			}
+		}(name, events)
+	}
+	go func() {
+		m.wg.Wait()
+		close(merged)
+	}()
+	return merged, nil
+}
+
+// Close closes each runtime. The first error is returned.
+func (m *multiRuntime) Close() error {
+	var firstErr error
+	for _, name := range m.names {
+		if err := m.runtimes[name].Close(); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "failed to close runtime %s", name)
+		}
+	}
+	m.wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,100 @@
+package beacon_test
+
+import (
+	beacon "."
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, ch <-chan *beacon.Event) *beacon.Event {
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return nil
+}
+
+func TestMultiRuntimeNewError(t *testing.T) {
+	tests := []map[string]beacon.Runtime{
+		nil,
+		{"": NewRuntime()},
+		{"docker/0": NewRuntime()},
+		{"docker-0": nil},
+	}
+	for _, runtimes := range tests {
+		if _, err := beacon.NewMultiRuntime(runtimes); err == nil {
+			t.Errorf("runtimes %v are valid", runtimes)
+		}
+	}
+}
+
+func TestMultiRuntime(t *testing.T) {
+	a, b := NewRuntime(), NewRuntime()
+	runtime, err := beacon.NewMultiRuntime(map[string]beacon.Runtime{"a": a, "b": b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := &beacon.Container{ID: "1", Service: "www"}
+	tests := []struct {
+		runtime *MockRuntime
+		event   *beacon.Event
+		want    *beacon.Event
+	}{
+		{
+			runtime: a,
+			event:   &beacon.Event{Action: beacon.Start, Container: sent},
+			want:    &beacon.Event{Action: beacon.Start, Container: &beacon.Container{ID: "a/1", Service: "www"}},
+		},
+		{
+			runtime: b,
+			event:   &beacon.Event{Action: beacon.Start, Container: &beacon.Container{ID: "1", Service: "db"}},
+			want:    &beacon.Event{Action: beacon.Start, Container: &beacon.Container{ID: "b/1", Service: "db"}},
+		},
+		{
+			// a has synced but b has not so no Synced event is sent
+			runtime: a,
+			event:   &beacon.Event{Action: beacon.Synced},
+		},
+		{
+			runtime: a,
+			event:   &beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: "1"}},
+			want:    &beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: "a/1"}},
+		},
+		{
+			runtime: b,
+			event:   &beacon.Event{Action: beacon.Synced},
+			want:    &beacon.Event{Action: beacon.Synced},
+		},
+	}
+	for n, test := range tests {
+		test.runtime.Events <- test.event
+		if test.want == nil {
+			continue
+		}
+		have := receiveEvent(t, ch)
+		if test.want.Container == nil {
+			if have.Action != test.want.Action || have.Container != nil {
+				t.Errorf("event %d: have %s %+v, want %s", n, have.Action, have.Container, test.want.Action)
+			}
+		} else if err := EventsEqual(have, test.want); err != nil {
+			t.Errorf("event %d: %s", n, err)
+		}
+	}
+	if sent.ID != "1" {
+		t.Errorf("runtime event was modified: have ID %s, want 1", sent.ID)
+	}
+
+	if err := runtime.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if event, ok := <-ch; ok {
+		t.Errorf("have event %s after close, want closed channel", event.Action)
+	}
+}
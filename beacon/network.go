@@ -39,3 +39,68 @@ func (b *Binding) Copy() *Binding {
 	cp := *b
 	return &cp
 }
+
+// Port is a port exposed by a container which is not bound on the host. It is
+// reachable on the container's network addresses.
+type Port struct {
+	ContainerPort int      // The port the container is listening on.
+	Protocol      Protocol // The protocol the port is configured for.
+}
+
+// Equal returns true if this Port is equal to another.
+func (p *Port) Equal(q *Port) bool {
+	if p == nil && q == nil {
+		return true
+	} else if p == nil || q == nil {
+		return false
+	}
+	return p.ContainerPort == q.ContainerPort && p.Protocol == q.Protocol
+}
+
+// Copy allocates a copy of the Port.
+func (p *Port) Copy() *Port {
+	if p == nil {
+		return nil
+	}
+	cp := *p
+	return &cp
+}
+
+// Network is a network which a container is attached to.
+type Network struct {
+	Name    string   // The name of the network.
+	IP      string   // The container's IPv4 address on the network.
+	IPv6    string   // The container's IPv6 address on the network.
+	Aliases []string // Names by which the container is known on the network.
+}
+
+// Equal returns true if this Network is equal to another.
+func (n *Network) Equal(m *Network) bool {
+	if n == nil && m == nil {
+		return true
+	} else if n == nil || m == nil {
+		return false
+	}
+	if n.Name != m.Name || n.IP != m.IP || n.IPv6 != m.IPv6 || len(n.Aliases) != len(m.Aliases) {
+		return false
+	}
+	for i, alias := range n.Aliases {
+		if alias != m.Aliases[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy allocates a copy of the Network.
+func (n *Network) Copy() *Network {
+	if n == nil {
+		return nil
+	}
+	cp := *n
+	if n.Aliases != nil {
+		cp.Aliases = make([]string, len(n.Aliases))
+		copy(cp.Aliases, n.Aliases)
+	}
+	return &cp
+}
@@ -0,0 +1,534 @@
+package beacon
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Overflow is the policy a queue applies when it is full.
+type Overflow string
+
+// Available overflow policies.
+const (
+	Block      Overflow = "block"       // Wait for space in the queue.
+	DropOldest          = "drop-oldest" // Discard the oldest queued event.
+	DropNewest          = "drop-newest" // Discard the event being queued.
+)
+
+// Default queue settings. These are used when a QueueConfig field is left at
+// its zero value.
+const (
+	DefaultQueueSize     = 64
+	DefaultQueueOverflow = Block
+	DefaultQueueTimeout  = 10 * time.Second
+)
+
+// QueueConfig controls how events are queued for delivery to a route.
+type QueueConfig struct {
+	// The name of the route. This is used to identify the route in logs and
+	// dead letters.
+	Name string
+
+	// The maximum number of events which may be waiting for delivery.
+	Size int
+
+	// What to do with an event when the queue is full.
+	Overflow Overflow
+
+	// How long Close waits for queued events to be delivered before
+	// discarding them.
+	Timeout time.Duration
+
+	// How to retry events which the backend fails to process.
+	Retry RetryPolicy
+
+	// Where to send events which could not be delivered. Undeliverable events
+	// are discarded if this is nil. The sink is closed with the queue.
+	DeadLetter DeadLetterSink
+
+	// A directory where queued events are persisted until they are delivered.
+	// Events left in the spool when the queue is closed are delivered when a
+	// queue is next created with the same spool. Events are only held in
+	// memory if this is empty.
+	Spool string
+}
+
+// NewQueue wraps a route in a bounded queue. Events are delivered to the
+// route's backend by a dedicated goroutine so that a slow backend does not
+// stall Beacon or any other route. Zero values in `config` are replaced with
+// their defaults. Events found in the spool are delivered before any new
+// events.
+func NewQueue(route Route, config QueueConfig) (Route, error) {
+	if route == nil {
+		return nil, errors.New("route cannot be nil")
+	}
+	if config.Size == 0 {
+		config.Size = DefaultQueueSize
+	} else if config.Size < 0 {
+		return nil, errors.Errorf("invalid queue size %d", config.Size)
+	}
+	if config.Overflow == "" {
+		config.Overflow = DefaultQueueOverflow
+	}
+	switch config.Overflow {
+	case Block, DropOldest, DropNewest:
+	default:
+		return nil, errors.Errorf("invalid queue overflow %s", config.Overflow)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultQueueTimeout
+	}
+	retry, err := config.Retry.validate()
+	if err != nil {
+		return nil, err
+	}
+	config.Retry = retry
+
+	q := &queue{
+		stats:   newRouteStats(config.Name),
+		route:   route,
+		config:  config,
+		entries: make(chan *entry, config.Size),
+		syncs:   make(chan *pendingSync, 1),
+		syncer:  syncer(route),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		abort:   make(chan struct{}),
+		done:    make(chan struct{}),
+		once:    &sync.Once{},
+		hold:    &sync.RWMutex{},
+	}
+
+	var spooled []*entry
+	if config.Spool != "" {
+		if q.spool, spooled, err = openSpool(config.Spool); err != nil {
+			return nil, err
+		}
+		if len(spooled) > 0 {
+			Logger.Printf("resuming delivery of %d spooled events on route %s", len(spooled), config.Name)
+		}
+	}
+	if reporter := failureReporter(route); reporter != nil {
+		reporter.SetFailureHandler(config.Retry, q.failed)
+	}
+	go q.run(spooled)
+	return q, nil
+}
+
+// queue is a Route which delivers events to another Route asynchronously.
+type queue struct {
+	stats   routeStats // first for 64-bit alignment
+	seq     uint64     // sequence number of the last queued entry
+	route   Route
+	config  QueueConfig
+	spool   *spool
+	entries chan *entry
+	syncs   chan *pendingSync
+	syncer  Syncer
+	stop    chan struct{}
+	stopped chan struct{}
+	abort   chan struct{}
+	done    chan struct{}
+	once    *sync.Once
+	hold    *sync.RWMutex
+}
+
+// pendingSync is a list of containers waiting to be synced. It is delivered
+// after the entries numbered up to `after`.
+type pendingSync struct {
+	containers []*Container
+	after      uint64
+}
+
+// MatchEvent matches against the wrapped route's filter.
+func (q *queue) MatchEvent(e *Event) bool {
+	return q.route.MatchEvent(e)
+}
+
+// ProcessEvent queues an event for delivery. The event is written to the spool
+// before it is queued. ProcessEvent blocks only when the queue is full and the
+// overflow policy is Block, or while the queue is held by AddRoute.
+func (q *queue) ProcessEvent(event *Event) error {
+	q.hold.RLock()
+	defer q.hold.RUnlock()
+	return q.enqueue(event)
+}
+
+// enqueue queues an event without waiting for the queue to be released.
+func (q *queue) enqueue(event *Event) error {
+	select {
+	case <-q.stop:
+		return errors.New("queue closed")
+	default:
+	}
+
+	e := &entry{event: event}
+	if q.spool != nil {
+		var err error
+		if e, err = q.spool.write(event); err != nil {
+			return err
+		}
+	}
+	e.seq = atomic.AddUint64(&q.seq, 1)
+
+	switch q.config.Overflow {
+	case DropNewest:
+		select {
+		case q.entries <- e:
+		default:
+			q.stats.addDropped()
+			q.release(e)
+			return errors.New("queue full")
+		}
+	case DropOldest:
+		for {
+			select {
+			case q.entries <- e:
+				q.stats.addRouted()
+				q.stats.setDepth(len(q.entries))
+				return nil
+			default:
+			}
+			select {
+			case old := <-q.entries:
+				Logger.Printf("queue full, dropping event %s for container %s on route %s", old.event.Action, old.event.Container.ID, q.config.Name)
+				q.stats.addDropped()
+				q.release(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.entries <- e:
+		case <-q.stop:
+			q.release(e)
+			return errors.New("queue closed")
+		}
+	}
+	q.stats.addRouted()
+	q.stats.setDepth(len(q.entries))
+	return nil
+}
+
+// sync queues the containers which match the route for its backend's Sync
+// method. A newer list replaces one which is still queued. It does nothing if
+// the backend is not a Syncer.
+func (q *queue) sync(containers []*Container) {
+	if q.syncer == nil {
+		return
+	}
+	s := &pendingSync{containers: []*Container{}, after: atomic.LoadUint64(&q.seq)}
+	for _, container := range containers {
+		if q.MatchEvent(&Event{Action: Start, Container: container}) {
+			s.containers = append(s.containers, container)
+		}
+	}
+	for {
+		select {
+		case q.syncs <- s:
+			return
+		default:
+		}
+		select {
+		case <-q.syncs:
+		default:
+		}
+	}
+}
+
+// run delivers spooled entries followed by queued entries to the route until
+// the queue is closed and drained or aborted.
+func (q *queue) run(spooled []*entry) {
+	defer close(q.done)
+	for _, e := range spooled {
+		select {
+		case <-q.abort:
+			return
+		default:
+		}
+		q.deliver(e)
+	}
+
+	var pending *pendingSync
+	for {
+		select {
+		case e := <-q.entries:
+			q.stats.setDepth(len(q.entries))
+			pending = q.deliverQueued(e, pending)
+		case s := <-q.syncs:
+			pending = q.syncQueued(s)
+		case <-q.stopped:
+			for {
+				select {
+				case <-q.abort:
+					return
+				default:
+				}
+				select {
+				case e := <-q.entries:
+					q.stats.setDepth(len(q.entries))
+					pending = q.deliverQueued(e, pending)
+				default:
+					if s := q.takeSync(pending); s != nil {
+						q.deliverSync(s.containers)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliverQueued delivers an entry taken from the queue. The pending sync is
+// delivered before the entry if the entry was queued after it, or after the
+// entry if no entries queued before it remain. It returns the sync which is
+// still pending.
+func (q *queue) deliverQueued(e *entry, pending *pendingSync) *pendingSync {
+	pending = q.takeSync(pending)
+	if pending != nil && e.seq > pending.after {
+		q.deliverSync(pending.containers)
+		pending = nil
+	}
+	q.deliver(e)
+	if pending != nil && (e.seq >= pending.after || len(q.entries) == 0) {
+		q.deliverSync(pending.containers)
+		pending = nil
+	}
+	return pending
+}
+
+// syncQueued delivers a sync taken from the queue if no entries queued before
+// it remain. It returns the sync if it is still pending.
+func (q *queue) syncQueued(s *pendingSync) *pendingSync {
+	if len(q.entries) == 0 {
+		q.deliverSync(s.containers)
+		return nil
+	}
+	return s
+}
+
+// takeSync returns the queued sync, which replaces the pending one, or the
+// pending sync if none is queued.
+func (q *queue) takeSync(pending *pendingSync) *pendingSync {
+	select {
+	case s := <-q.syncs:
+		return s
+	default:
+		return pending
+	}
+}
+
+// deliver an entry to the route. Failed deliveries are retried according to
+// the retry policy until they succeed, fail permanently, or the queue is
+// aborted. Events which are not delivered are dead lettered. Events aborted
+// while spooled are left in the spool.
+func (q *queue) deliver(e *entry) {
+	event := e.event
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := q.route.ProcessEvent(event)
+		q.stats.observe(time.Since(start))
+		if err == nil {
+			q.stats.addDelivered()
+			q.release(e)
+			return
+		}
+		if IsPermanent(err) || attempt >= q.config.Retry.Attempts {
+			q.deadLetter(e, err, attempt)
+			return
+		}
+
+		q.stats.addRetried()
+		delay := q.config.Retry.Delay(attempt)
+		Logger.Printf("retrying event %s for container %s on route %s in %s: %s", event.Action, event.Container.ID, q.config.Name, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-q.abort:
+			if e.file == "" {
+				q.deadLetter(e, errors.Wrap(err, "queue closed"), attempt)
+			}
+			return
+		}
+	}
+}
+
+// deliverSync calls the backend's Sync method, retrying failures according to
+// the retry policy until it succeeds or the queue is aborted.
+func (q *queue) deliverSync(containers []*Container) {
+	for attempt := 1; ; attempt++ {
+		err := q.syncer.Sync(containers)
+		if err == nil {
+			return
+		}
+		if IsPermanent(err) || attempt >= q.config.Retry.Attempts {
+			Logger.Printf("failed to sync route %s after %d attempts: %s", q.config.Name, attempt, err)
+			return
+		}
+		delay := q.config.Retry.Delay(attempt)
+		Logger.Printf("retrying sync of route %s in %s: %s", q.config.Name, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-q.abort:
+			return
+		}
+	}
+}
+
+// deadLetter sends an undeliverable entry to the dead letter sink and releases
+// it.
+func (q *queue) deadLetter(e *entry, err error, attempts int) {
+	defer q.release(e)
+	q.stats.addFailed()
+	event := e.event
+	if q.config.DeadLetter == nil {
+		Logger.Printf("discarding event %s for container %s on route %s after %d attempts: %s", event.Action, event.Container.ID, q.config.Name, attempts, err)
+		return
+	}
+	letter := &DeadLetter{
+		Route:    q.config.Name,
+		Event:    event,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Time:     time.Now().UTC(),
+	}
+	if dlErr := q.config.DeadLetter.DeadLetter(letter); dlErr != nil {
+		Logger.Printf("discarding event %s for container %s on route %s after %d attempts: %s: failed to dead letter: %s", event.Action, event.Container.ID, q.config.Name, attempts, err, dlErr)
+		return
+	}
+	Logger.Printf("dead lettered event %s for container %s on route %s after %d attempts: %s", event.Action, event.Container.ID, q.config.Name, attempts, err)
+}
+
+// failed dead letters an event which the backend accepted but failed to
+// deliver.
+func (q *queue) failed(event *Event, err error, attempts int) {
+	q.deadLetter(&entry{event: event}, err, attempts)
+}
+
+// failureReporter returns the route, or the backend of a route created by
+// NewRoute, if it reports failures. It returns nil otherwise.
+func failureReporter(r Route) FailureReporter {
+	if reporter, ok := r.(FailureReporter); ok {
+		return reporter
+	}
+	if std, ok := r.(*route); ok {
+		if reporter, ok := std.Backend.(FailureReporter); ok {
+			return reporter
+		}
+	}
+	return nil
+}
+
+// syncer returns the route, or the backend of a route created by NewRoute, if
+// it is a Syncer. It returns nil otherwise.
+func syncer(r Route) Syncer {
+	if s, ok := r.(Syncer); ok {
+		return s
+	}
+	if std, ok := r.(*route); ok {
+		if s, ok := std.Backend.(Syncer); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// setFilter replaces the wrapped route's filter. The wrapped route must have
+// been created by NewRoute.
+func (q *queue) setFilter(filter Filter) error {
+	r, ok := q.route.(*route)
+	if !ok {
+		return errors.Errorf("route %s does not support filter updates", q.config.Name)
+	}
+	r.setFilter(filter)
+	return nil
+}
+
+// Stats returns the route's delivery statistics.
+func (q *queue) Stats() *RouteStats {
+	stats := q.stats.get()
+	stats.Name = q.config.Name
+	stats.Queued = len(q.entries)
+	return &stats
+}
+
+// release an entry which is no longer queued by removing it from the spool.
+func (q *queue) release(e *entry) {
+	if q.spool != nil && e.file != "" {
+		q.spool.remove(e.file)
+	}
+}
+
+// Close stops accepting events and waits up to the configured timeout for
+// queued events to be delivered. Events still queued after the timeout are
+// left in the spool or, if the queue is not spooled, dead lettered. The
+// wrapped route and dead letter sink are closed once the event being delivered,
+// if any, is done. If it is not done by the timeout then Close returns and
+// they are closed when the delivery completes.
+func (q *queue) Close() error {
+	var err error
+	q.once.Do(func() {
+		deadline := time.After(q.config.Timeout)
+		close(q.stop)
+		// wait for enqueues in progress to give up so that the worker only
+		// drains the queue once nothing more can be added to it
+		q.hold.Lock()
+		close(q.stopped)
+		q.hold.Unlock()
+
+		select {
+		case <-q.done:
+		case <-deadline:
+			close(q.abort)
+			undelivered := 0
+		Drain:
+			for {
+				select {
+				case e := <-q.entries:
+					if q.spool == nil {
+						q.deadLetter(e, errors.New("queue closed"), 0)
+					}
+					undelivered++
+				default:
+					break Drain
+				}
+			}
+			q.stats.setDepth(len(q.entries))
+			if q.spool != nil {
+				err = errors.Errorf("timed out draining queue, %d events left in spool", undelivered)
+			} else {
+				err = errors.Errorf("timed out draining queue, %d events discarded", undelivered)
+			}
+
+			// the worker may still be in the backend or dead lettering
+			select {
+			case <-q.done:
+			default:
+				Logger.Printf("route %s is still delivering an event, it will be closed when done", q.config.Name)
+				go func() {
+					<-q.done
+					if closeErr := q.closeRoute(); closeErr != nil {
+						Logger.Printf("failed to close route %s: %s", q.config.Name, closeErr)
+					}
+				}()
+				return
+			}
+		}
+		if closeErr := q.closeRoute(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// closeRoute closes the wrapped route and the dead letter sink.
+func (q *queue) closeRoute() error {
+	err := q.route.Close()
+	if q.config.DeadLetter != nil {
+		if closeErr := q.config.DeadLetter.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,279 @@
+package beacon_test
+
+import (
+	beacon "."
+	"fmt"
+	"github.com/pkg/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// GateBackend blocks in ProcessEvent until the gate is opened.
+type GateBackend struct {
+	Entered chan *beacon.Event
+	Gate    chan struct{}
+	Events  chan *beacon.Event
+}
+
+func NewGateBackend() *GateBackend {
+	return &GateBackend{
+		Entered: make(chan *beacon.Event, 16),
+		Gate:    make(chan struct{}),
+		Events:  make(chan *beacon.Event, 16),
+	}
+}
+
+// ProcessEvent waits for the gate to open before accepting the event.
+func (b *GateBackend) ProcessEvent(event *beacon.Event) error {
+	b.Entered <- event
+	<-b.Gate
+	b.Events <- event
+	return nil
+}
+
+// Close is a noop.
+func (b *GateBackend) Close() error {
+	return nil
+}
+
+func QueueEvent(id string) *beacon.Event {
+	return &beacon.Event{
+		Action: beacon.Start,
+		Container: &beacon.Container{
+			ID:      id,
+			Service: "example",
+		},
+	}
+}
+
+// newGatedQueue creates a queue and waits for the first event to block in the
+// backend.
+func newGatedQueue(t *testing.T, config beacon.QueueConfig) (beacon.Route, *GateBackend) {
+	backend := NewGateBackend()
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, backend), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.ProcessEvent(QueueEvent("0")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-backend.Entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for backend")
+	}
+	return queue, backend
+}
+
+func TestQueueNewError(t *testing.T) {
+	t.Parallel()
+	route := beacon.NewRoute(nil, NewBackend())
+	if _, err := beacon.NewQueue(nil, beacon.QueueConfig{}); err == nil {
+		t.Error("expected error for nil route")
+	}
+	if _, err := beacon.NewQueue(route, beacon.QueueConfig{Size: -1}); err == nil {
+		t.Error("expected error for negative size")
+	}
+	if _, err := beacon.NewQueue(route, beacon.QueueConfig{Overflow: "sideways"}); err == nil {
+		t.Error("expected error for invalid overflow")
+	}
+}
+
+func TestQueueDropNewest(t *testing.T) {
+	t.Parallel()
+	queue, backend := newGatedQueue(t, beacon.QueueConfig{Size: 2, Overflow: beacon.DropNewest})
+
+	for n := 1; n <= 3; n++ {
+		err := queue.ProcessEvent(QueueEvent(fmt.Sprint(n)))
+		if n <= 2 && err != nil {
+			t.Errorf("event %d: %s", n, err)
+		} else if n > 2 && err == nil {
+			t.Errorf("event %d: expected queue full error", n)
+		}
+	}
+
+	close(backend.Gate)
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(backend.Events)
+
+	have := []string{}
+	for event := range backend.Events {
+		have = append(have, event.Container.ID)
+	}
+	if want := "[0 1 2]"; fmt.Sprint(have) != want {
+		t.Errorf("delivered %v, want %s", have, want)
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	t.Parallel()
+	queue, backend := newGatedQueue(t, beacon.QueueConfig{Size: 2, Overflow: beacon.DropOldest})
+
+	for n := 1; n <= 3; n++ {
+		if err := queue.ProcessEvent(QueueEvent(fmt.Sprint(n))); err != nil {
+			t.Errorf("event %d: %s", n, err)
+		}
+	}
+
+	close(backend.Gate)
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(backend.Events)
+
+	have := []string{}
+	for event := range backend.Events {
+		have = append(have, event.Container.ID)
+	}
+	if want := "[0 2 3]"; fmt.Sprint(have) != want {
+		t.Errorf("delivered %v, want %s", have, want)
+	}
+}
+
+func TestQueueCloseTimeout(t *testing.T) {
+	t.Parallel()
+	queue, backend := newGatedQueue(t, beacon.QueueConfig{Size: 2, Timeout: 50 * time.Millisecond})
+	defer close(backend.Gate)
+
+	if err := queue.ProcessEvent(QueueEvent("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := queue.Close(); err == nil {
+		t.Error("expected error when queue fails to drain")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("close took %s", elapsed)
+	}
+	if err := queue.ProcessEvent(QueueEvent("2")); err == nil {
+		t.Error("expected error queueing to a closed queue")
+	}
+}
+
+func TestQueueCloseBlocked(t *testing.T) {
+	t.Parallel()
+	for n := 0; n < 20; n++ {
+		queue, backend := newGatedQueue(t, beacon.QueueConfig{Size: 1})
+		if err := queue.ProcessEvent(QueueEvent("1")); err != nil {
+			t.Fatal(err)
+		}
+
+		// an event blocked on the full queue is either delivered or rejected
+		errs := make(chan error, 1)
+		go func() {
+			errs <- queue.ProcessEvent(QueueEvent("2"))
+		}()
+		close(backend.Gate)
+		if err := queue.Close(); err != nil {
+			t.Fatal(err)
+		}
+		close(backend.Events)
+
+		have := []string{}
+		for event := range backend.Events {
+			have = append(have, event.Container.ID)
+		}
+		want := "[0 1]"
+		if err := <-errs; err == nil {
+			want = "[0 1 2]"
+		}
+		if fmt.Sprint(have) != want {
+			t.Fatalf("delivered %v, want %s", have, want)
+		}
+	}
+}
+
+// SlowBackend fails each event after a delay. It records whether it was
+// closed while processing an event.
+type SlowBackend struct {
+	Delay      time.Duration
+	lock       sync.Mutex
+	busy       bool
+	ClosedBusy bool
+}
+
+func (b *SlowBackend) ProcessEvent(event *beacon.Event) error {
+	b.lock.Lock()
+	b.busy = true
+	b.lock.Unlock()
+	time.Sleep(b.Delay)
+	b.lock.Lock()
+	b.busy = false
+	b.lock.Unlock()
+	return errors.New("slow failure")
+}
+
+func (b *SlowBackend) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.ClosedBusy = b.busy
+	return nil
+}
+
+// ClosingSink records dead letters and whether any arrived after it closed.
+type ClosingSink struct {
+	Closed      chan struct{}
+	lock        sync.Mutex
+	Letters     int
+	LateLetters int
+}
+
+func (s *ClosingSink) DeadLetter(letter *beacon.DeadLetter) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	select {
+	case <-s.Closed:
+		s.LateLetters++
+	default:
+		s.Letters++
+	}
+	return nil
+}
+
+func (s *ClosingSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	close(s.Closed)
+	return nil
+}
+
+func TestQueueCloseWaitsForDelivery(t *testing.T) {
+	t.Parallel()
+	backend := &SlowBackend{Delay: 300 * time.Millisecond}
+	sink := &ClosingSink{Closed: make(chan struct{})}
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, backend), beacon.QueueConfig{
+		Timeout:    50 * time.Millisecond,
+		Retry:      beacon.RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+		DeadLetter: sink,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.ProcessEvent(QueueEvent("0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Close(); err == nil {
+		t.Error("expected error when queue fails to drain")
+	}
+
+	// the backend and sink are closed once the event is dead lettered
+	select {
+	case <-sink.Closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the sink to close")
+	}
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.ClosedBusy {
+		t.Error("backend closed while processing an event")
+	}
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.Letters != 1 || sink.LateLetters != 0 {
+		t.Errorf("have %d dead letters and %d after close, want 1 and 0", sink.Letters, sink.LateLetters)
+	}
+}
@@ -0,0 +1,258 @@
+package beacon_test
+
+import (
+	beacon "."
+	"sync"
+	"testing"
+	"time"
+)
+
+// runBeacon starts a beacon with a single named route and returns it along
+// with its backend. The returned function stops the beacon.
+func runBeacon(t *testing.T, runtime *MockRuntime) (beacon.Beacon, *MockBackend, func()) {
+	backend := NewBackend()
+	route, err := beacon.NewQueue(beacon.NewRoute(nil, backend), beacon.QueueConfig{Name: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcn, err := beacon.New(runtime, []beacon.Route{route})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bcn.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+	return bcn, backend, func() {
+		if err := bcn.Close(); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+	}
+}
+
+func routeNames(bcn beacon.Beacon) []string {
+	names := []string{}
+	for _, stats := range bcn.Routes() {
+		names = append(names, stats.Name)
+	}
+	return names
+}
+
+func TestBeaconAddRoute(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	bcn, backend, stop := runBeacon(t, runtime)
+	defer stop()
+
+	red := &beacon.Container{ID: "1", Service: "example", Labels: map[string]string{"color": "red"}, Bindings: []*beacon.Binding{}}
+	blue := &beacon.Container{ID: "2", Service: "example", Labels: map[string]string{"color": "blue"}, Bindings: []*beacon.Binding{}}
+	runtime.Events <- &beacon.Event{Action: beacon.Start, Container: red}
+	runtime.Events <- &beacon.Event{Action: beacon.Start, Container: blue}
+	if _, err := backend.WaitForEvents(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	redBackend := NewBackend()
+	redRoute, err := beacon.NewQueue(
+		beacon.NewRoute(beacon.NewFilter(map[string]string{"color": "red"}), redBackend),
+		beacon.QueueConfig{Name: "red"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bcn.AddRoute(redRoute); err != nil {
+		t.Fatal(err)
+	}
+	haveEvents, err := redBackend.WaitForEvents(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EventArraysEqual(haveEvents, []*beacon.Event{{Action: beacon.Start, Container: red}}); err != nil {
+		t.Error(err)
+	}
+
+	runtime.Events <- &beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: "1"}}
+	haveEvents, err = redBackend.WaitForEvents(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EventArraysEqual(haveEvents, []*beacon.Event{{Action: beacon.Stop, Container: red}}); err != nil {
+		t.Error(err)
+	}
+	if _, err := backend.WaitForEvents(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := routeNames(bcn); len(names) != 2 || names[1] != "red" {
+		t.Errorf("routes are %v, want [all red]", names)
+	}
+	duplicate, err := beacon.NewQueue(beacon.NewRoute(nil, NewBackend()), beacon.QueueConfig{Name: "red"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bcn.AddRoute(duplicate); err == nil {
+		t.Error("expected error adding duplicate route")
+	}
+}
+
+func TestBeaconAddRouteBlocked(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	bcn, backend, stop := runBeacon(t, runtime)
+	defer stop()
+
+	for _, id := range []string{"1", "2"} {
+		runtime.Events <- QueueEvent(id)
+	}
+	if _, err := backend.WaitForEvents(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the queue fills up while its Start events are queued
+	route, gated := newGatedQueue(t, beacon.QueueConfig{Name: "gated", Size: 1})
+	added := make(chan error, 1)
+	go func() {
+		added <- bcn.AddRoute(route)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); len(routeNames(bcn)) != 2; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for route to be added")
+		}
+	}
+	runtime.Events <- &beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: "1"}}
+	close(gated.Gate)
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+
+	// the Stop is delivered after the Start it follows
+	have := []string{}
+	for len(have) < 4 {
+		select {
+		case event := <-gated.Events:
+			have = append(have, string(event.Action)+" "+event.Container.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("have events %v, want 4", have)
+		}
+	}
+	if have[3] != "stop 1" {
+		t.Errorf("have events %v, want stop 1 last", have)
+	}
+	if _, err := backend.WaitForEvents(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBeaconAddRouteWhileDispatching(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	bcn, backend, stop := runBeacon(t, runtime)
+	defer stop()
+
+	route, gated := newGatedQueue(t, beacon.QueueConfig{Name: "gated", Size: 1})
+	if err := bcn.AddRoute(route); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event loop blocks on the full queue
+	runtime.Events <- QueueEvent("1")
+	runtime.Events <- QueueEvent("2")
+	other, err := beacon.NewQueue(
+		beacon.NewRoute(beacon.NewFilter(map[string]string{"color": "red"}), NewBackend()),
+		beacon.QueueConfig{Name: "other"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := make(chan error, 1)
+	go func() {
+		added <- bcn.AddRoute(other)
+	}()
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out adding a route while the event loop is blocked")
+	}
+
+	close(gated.Gate)
+	if _, err := backend.WaitForEvents(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBeaconAddRouteStopped(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	bcn, _, stop := runBeacon(t, runtime)
+	stop()
+
+	route, err := beacon.NewQueue(beacon.NewRoute(nil, NewBackend()), beacon.QueueConfig{Name: "late"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bcn.AddRoute(route); err == nil {
+		t.Error("expected error adding a route to a stopped beacon")
+	}
+	if err := route.ProcessEvent(QueueEvent("1")); err == nil {
+		t.Error("route added to a stopped beacon was not closed")
+	}
+}
+
+func TestBeaconRemoveRoute(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	bcn, _, stop := runBeacon(t, runtime)
+	defer stop()
+
+	otherRoute, err := beacon.NewQueue(beacon.NewRoute(nil, NewBackend()), beacon.QueueConfig{Name: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bcn.AddRoute(otherRoute); err != nil {
+		t.Fatal(err)
+	}
+	if err := bcn.RemoveRoute("all"); err != nil {
+		t.Fatal(err)
+	}
+	if names := routeNames(bcn); len(names) != 1 || names[0] != "other" {
+		t.Errorf("routes are %v, want [other]", names)
+	}
+	if err := bcn.RemoveRoute("all"); err == nil {
+		t.Error("expected error removing missing route")
+	}
+}
+
+func TestBeaconUpdateFilter(t *testing.T) {
+	t.Parallel()
+	runtime := NewRuntime()
+	bcn, backend, stop := runBeacon(t, runtime)
+	defer stop()
+
+	if err := bcn.UpdateFilter("all", beacon.NewFilter(map[string]string{"color": "blue"})); err != nil {
+		t.Fatal(err)
+	}
+	red := &beacon.Container{ID: "1", Service: "example", Labels: map[string]string{"color": "red"}, Bindings: []*beacon.Binding{}}
+	blue := &beacon.Container{ID: "2", Service: "example", Labels: map[string]string{"color": "blue"}, Bindings: []*beacon.Binding{}}
+	runtime.Events <- &beacon.Event{Action: beacon.Start, Container: red}
+	runtime.Events <- &beacon.Event{Action: beacon.Start, Container: blue}
+	haveEvents, err := backend.WaitForEvents(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EventArraysEqual(haveEvents, []*beacon.Event{{Action: beacon.Start, Container: blue}}); err != nil {
+		t.Error(err)
+	}
+
+	if err := bcn.UpdateFilter("missing", nil); err == nil {
+		t.Error("expected error updating missing route")
+	}
+}
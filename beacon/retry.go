@@ -0,0 +1,95 @@
+package beacon
+
+import (
+	"github.com/pkg/errors"
+	"math/rand"
+	"time"
+)
+
+// Default retry settings. These are used when a RetryPolicy field is left at
+// its zero value.
+const (
+	DefaultRetryAttempts   = 3
+	DefaultRetryBackoff    = time.Second
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
+// RetryPolicy controls how a queue retries events which a backend fails to
+// process. The delay before each retry starts at Backoff and doubles with each
+// attempt up to MaxBackoff.
+type RetryPolicy struct {
+	// The maximum number of times to attempt delivery of an event. A value of
+	// one disables retries.
+	Attempts int
+
+	// The delay before the first retry.
+	Backoff time.Duration
+
+	// The upper limit on the delay between retries.
+	MaxBackoff time.Duration
+
+	// The fraction of each delay, from 0 to 1, which is randomized. Zero
+	// disables jitter.
+	Jitter float64
+}
+
+// validate the policy and fill in default values.
+func (p RetryPolicy) validate() (RetryPolicy, error) {
+	if p.Attempts == 0 {
+		p.Attempts = DefaultRetryAttempts
+	} else if p.Attempts < 0 {
+		return p, errors.Errorf("invalid retry attempts %d", p.Attempts)
+	}
+	if p.Backoff == 0 {
+		p.Backoff = DefaultRetryBackoff
+	} else if p.Backoff < 0 {
+		return p, errors.Errorf("invalid retry backoff %s", p.Backoff)
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if p.MaxBackoff < p.Backoff {
+		return p, errors.Errorf("retry max backoff %s is less than backoff %s", p.MaxBackoff, p.Backoff)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return p, errors.Errorf("invalid retry jitter %g", p.Jitter)
+	}
+	return p, nil
+}
+
+// Delay returns how long to wait after the given failed attempt.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for n := 1; n < attempt && delay < p.MaxBackoff; n++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+// Permanent marks an error as permanent. A queue does not retry events whose
+// delivery fails with a permanent error. Backends should return permanent
+// errors for failures which will not succeed on retry, such as an invalid
+// request.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// IsPermanent returns true if the error or its cause was created by Permanent.
+func IsPermanent(err error) bool {
+	_, ok := errors.Cause(err).(*permanentError)
+	return ok
+}
+
+// permanentError wraps an error which should not be retried.
+type permanentError struct {
+	error
+}
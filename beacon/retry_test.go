@@ -0,0 +1,143 @@
+package beacon_test
+
+import (
+	beacon "."
+	"github.com/pkg/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FailingBackend fails the first Failures calls to ProcessEvent with Err.
+type FailingBackend struct {
+	Failures int
+	Err      error
+	Attempts chan *beacon.Event
+	lock     sync.Mutex
+	calls    int
+}
+
+func NewFailingBackend(failures int, err error) *FailingBackend {
+	return &FailingBackend{
+		Failures: failures,
+		Err:      err,
+		Attempts: make(chan *beacon.Event, 16),
+	}
+}
+
+// ProcessEvent records the attempt and fails if there are failures remaining.
+func (b *FailingBackend) ProcessEvent(event *beacon.Event) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.Attempts <- event
+	b.calls++
+	if b.calls <= b.Failures {
+		return b.Err
+	}
+	return nil
+}
+
+// Close is a noop.
+func (b *FailingBackend) Close() error {
+	return nil
+}
+
+// Calls returns the number of times ProcessEvent was called.
+func (b *FailingBackend) Calls() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.calls
+}
+
+func testRetry(t *testing.T, backend *FailingBackend, retry beacon.RetryPolicy, wantCalls int) {
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, backend), beacon.QueueConfig{Retry: retry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.ProcessEvent(QueueEvent("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := backend.Calls(); calls != wantCalls {
+		t.Errorf("backend called %d times, want %d", calls, wantCalls)
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	t.Parallel()
+	backend := NewFailingBackend(2, errors.New("transient"))
+	retry := beacon.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Jitter: 0.5}
+	testRetry(t, backend, retry, 3)
+}
+
+func TestRetryExhausted(t *testing.T) {
+	t.Parallel()
+	backend := NewFailingBackend(5, errors.New("transient"))
+	retry := beacon.RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	testRetry(t, backend, retry, 3)
+}
+
+func TestRetryPermanent(t *testing.T) {
+	t.Parallel()
+	backend := NewFailingBackend(5, errors.Wrap(beacon.Permanent(errors.New("bad request")), "failed"))
+	retry := beacon.RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	testRetry(t, backend, retry, 1)
+}
+
+func TestRetryAbort(t *testing.T) {
+	t.Parallel()
+	backend := NewFailingBackend(5, errors.New("transient"))
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, backend), beacon.QueueConfig{
+		Timeout: 10 * time.Millisecond,
+		Retry:   beacon.RetryPolicy{Attempts: 5, Backoff: time.Hour, MaxBackoff: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.ProcessEvent(QueueEvent("1")); err != nil {
+		t.Fatal(err)
+	}
+	<-backend.Attempts
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked on retry backoff")
+	}
+}
+
+func TestRetryPolicyInvalid(t *testing.T) {
+	t.Parallel()
+	route := beacon.NewRoute(nil, NewBackend())
+	policies := []beacon.RetryPolicy{
+		{Attempts: -1},
+		{Backoff: -time.Second},
+		{Backoff: time.Minute, MaxBackoff: time.Second},
+		{Jitter: 1.5},
+	}
+	for n, policy := range policies {
+		if _, err := beacon.NewQueue(route, beacon.QueueConfig{Retry: policy}); err == nil {
+			t.Errorf("policy %d: expected error", n)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	t.Parallel()
+	if beacon.IsPermanent(errors.New("transient")) {
+		t.Error("plain error is permanent")
+	}
+	if beacon.Permanent(nil) != nil {
+		t.Error("Permanent(nil) is not nil")
+	}
+	if !beacon.IsPermanent(errors.Wrap(beacon.Permanent(errors.New("bad")), "wrapped")) {
+		t.Error("wrapped permanent error is not permanent")
+	}
+}
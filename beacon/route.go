@@ -1,21 +1,49 @@
 package beacon
 
-// NewRoute creates a route from the provided filter and backend.
+import (
+	"sync"
+)
+
+// NewRoute creates a route from the provided filter and backend. Events are
+// matched using the filter's MatchEvent method if it implements EventFilter
+// and are otherwise matched on their container.
 func NewRoute(filter Filter, backend Backend) Route {
 	if filter == nil {
 		filter = &allFilter{}
 	}
-	return struct {
-		Filter
-		Backend
-	}{
-		filter,
-		backend,
+	return &route{
+		filter:  filter,
+		lock:    &sync.RWMutex{},
+		Backend: backend,
 	}
 }
 
 // Route processes events which match a particular filter pattern.
 type Route interface {
-	Filter
+	EventFilter
+	Backend
+}
+
+// route is the standard Route implementation.
+type route struct {
+	filter Filter
+	lock   *sync.RWMutex
 	Backend
 }
+
+// MatchEvent matches the event against the route's filter.
+func (r *route) MatchEvent(e *Event) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return matchEvent(r.filter, e)
+}
+
+// setFilter replaces the route's filter.
+func (r *route) setFilter(filter Filter) {
+	if filter == nil {
+		filter = &allFilter{}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.filter = filter
+}
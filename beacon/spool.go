@@ -0,0 +1,128 @@
+package beacon
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const spoolExt = ".json"
+
+// entry is an event waiting in a queue. The file is the path to the event in
+// the spool or empty if the queue is not spooled. The sequence number orders
+// the entries queued since the queue was created and is zero for spooled
+// entries left by an earlier queue.
+type entry struct {
+	event *Event
+	file  string
+	seq   uint64
+}
+
+// spool persists queued events to a directory so that they survive restarts.
+// Each event is stored in its own file named after its sequence number.
+type spool struct {
+	dir  string
+	seq  uint64
+	lock *sync.Mutex
+}
+
+// openSpool opens the spool in `dir`, creating it if necessary. It returns the
+// spool and the entries persisted in it in the order they were written. File
+// names are zero padded so the directory listing is already in order.
+func openSpool(dir string) (*spool, []*entry, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to create spool %s", dir)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read spool %s", dir)
+	}
+
+	s := &spool{
+		dir:  dir,
+		lock: &sync.Mutex{},
+	}
+	seqs := []uint64{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+
+	entries := make([]*entry, 0, len(seqs))
+	for _, seq := range seqs {
+		s.seq = seq
+		file := s.path(seq)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to read spooled event %s", file)
+		}
+		event := &Event{}
+		if err := json.Unmarshal(data, event); err != nil || event.Container == nil {
+			Logger.Printf("discarding corrupt spooled event %s", file)
+			s.remove(file)
+			continue
+		}
+		entries = append(entries, &entry{event: event, file: file})
+	}
+	return s, entries, nil
+}
+
+// path returns the path to the file for the given sequence number.
+func (s *spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolExt))
+}
+
+// write persists an event to the spool and returns its entry. The event is
+// written to a temporary file and renamed into place so a partially written
+// event is never read back.
+func (s *spool) write(event *Event) (*entry, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize event")
+	}
+
+	s.lock.Lock()
+	s.seq++
+	file := s.path(s.seq)
+	s.lock.Unlock()
+
+	tmp, err := ioutil.TempFile(s.dir, ".spool")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to spool event")
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, errors.Wrap(err, "failed to spool event")
+	}
+	return &entry{event: event, file: file}, nil
+}
+
+// remove an event from the spool.
+func (s *spool) remove(file string) {
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		Logger.Printf("failed to remove spooled event %s: %s", file, err)
+	}
+}
@@ -0,0 +1,134 @@
+package beacon_test
+
+import (
+	beacon "."
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func SpoolFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// WaitForSpoolFiles waits up to `timeout` for the spool to contain `n` events.
+func WaitForSpoolFiles(t *testing.T, dir string, n int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		files := SpoolFiles(t, dir)
+		if len(files) == n {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("spool has %d events, want %d", len(files), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpoolResume(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// queue two events behind a blocked backend and close before delivery
+	config := beacon.QueueConfig{Timeout: 10 * time.Millisecond, Spool: dir}
+	blocked, gate := newGatedQueue(t, config)
+	if err := blocked.ProcessEvent(QueueEvent("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocked.Close(); err == nil {
+		t.Error("expected error when queue fails to drain")
+	}
+	if files := SpoolFiles(t, dir); len(files) != 2 {
+		t.Fatalf("spool has %d events, want 2", len(files))
+	}
+
+	// the event stuck in the backend is removed from the spool once delivered
+	close(gate.Gate)
+	WaitForSpoolFiles(t, dir, 1, 5*time.Second)
+
+	// a new queue on the same spool delivers the remaining event
+	backend := NewBackend()
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, backend), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.ProcessEvent(QueueEvent("2")); err != nil {
+		t.Fatal(err)
+	}
+	haveEvents, err := backend.WaitForEvents(2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EventArraysEqual(haveEvents, []*beacon.Event{QueueEvent("1"), QueueEvent("2")}); err != nil {
+		t.Error(err)
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := SpoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("spool has %d events, want 0", len(files))
+	}
+}
+
+func TestSpoolDropNewest(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, backend := newGatedQueue(t, beacon.QueueConfig{Size: 1, Overflow: beacon.DropNewest, Spool: dir})
+	if err := queue.ProcessEvent(QueueEvent("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.ProcessEvent(QueueEvent("2")); err == nil {
+		t.Error("expected queue full error")
+	}
+	if files := SpoolFiles(t, dir); len(files) != 2 {
+		t.Errorf("spool has %d events, want 2", len(files))
+	}
+
+	close(backend.Gate)
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := SpoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("spool has %d events, want 0", len(files))
+	}
+}
+
+func TestSpoolCorrupt(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "00000000000000000001.json")
+	if err := ioutil.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := beacon.NewQueue(beacon.NewRoute(nil, NewBackend()), beacon.QueueConfig{Spool: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := SpoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("spool has %d events, want 0", len(files))
+	}
+}
@@ -0,0 +1,72 @@
+package beacon
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// state is the container state which Beacon persists between runs.
+type state struct {
+	Containers []*Container
+}
+
+// loadState reads the containers saved in the state file at `path`. A missing
+// file is treated as an empty state.
+func loadState(path string) (map[string]*Container, error) {
+	containers := map[string]*Container{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return containers, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to read state file %s", path)
+	}
+
+	saved := &state{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse state file %s", path)
+	}
+	for _, container := range saved.Containers {
+		if container != nil && container.ID != "" {
+			containers[container.ID] = container
+		}
+	}
+	return containers, nil
+}
+
+// saveState writes the containers to the state file at `path`. The state is
+// written to a temporary file which is then renamed over the state file.
+func saveState(path string, containers map[string]*Container) error {
+	saved := &state{
+		Containers: make([]*Container, 0, len(containers)),
+	}
+	for _, container := range containers {
+		saved.Containers = append(saved.Containers, container)
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize state")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".beacon-state")
+	if err != nil {
+		return errors.Wrapf(err, "failed to write state file %s", path)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write state file %s", path)
+	}
+	return nil
+}
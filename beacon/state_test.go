@@ -0,0 +1,270 @@
+package beacon_test
+
+import (
+	beacon "."
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type State struct {
+	Containers []*beacon.Container
+}
+
+func WriteState(t *testing.T, path string, containers []*beacon.Container) {
+	data, err := json.Marshal(&State{Containers: containers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ReadState(t *testing.T, path string) []*beacon.Container {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		t.Fatal(err)
+	}
+	return state.Containers
+}
+
+func TestBeaconStateReconcile(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	unchanged := &beacon.Container{
+		ID:       "1",
+		Service:  "example",
+		Labels:   map[string]string{"color": "red"},
+		Bindings: []*beacon.Binding{},
+	}
+	changed := &beacon.Container{
+		ID:       "2",
+		Service:  "example",
+		Labels:   map[string]string{"color": "green"},
+		Bindings: []*beacon.Binding{},
+	}
+	stopped := &beacon.Container{
+		ID:       "3",
+		Service:  "example",
+		Labels:   map[string]string{"color": "blue"},
+		Bindings: []*beacon.Binding{},
+	}
+	WriteState(t, path, []*beacon.Container{unchanged, changed, stopped})
+
+	runtime := NewRuntime()
+	backend := NewBackend()
+	bcn, err := beacon.NewWithState(runtime, []beacon.Route{beacon.NewRoute(nil, backend)}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bcn.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	changedNow := changed.Copy()
+	changedNow.Labels["color"] = "yellow"
+	go func() {
+		runtime.Events <- &beacon.Event{Action: beacon.Start, Container: unchanged.Copy()}
+		runtime.Events <- &beacon.Event{Action: beacon.Start, Container: changedNow}
+		runtime.Events <- &beacon.Event{Action: beacon.Synced}
+	}()
+
+	haveEvents, err := backend.WaitForEvents(2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEvents := []*beacon.Event{
+		{Action: beacon.Update, Container: changedNow},
+		{Action: beacon.Stop, Container: stopped},
+	}
+	if err := EventArraysEqual(haveEvents, wantEvents); err != nil {
+		t.Error(err)
+	}
+
+	if err := bcn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	wantContainers := []*beacon.Container{unchanged, changedNow}
+	if err := ContainerSetsEqual(ReadState(t, path), wantContainers); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBeaconStateSaved(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	runtime := NewRuntime()
+	backend := NewBackend()
+	bcn, err := beacon.NewWithState(runtime, []beacon.Route{beacon.NewRoute(nil, backend)}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bcn.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	container := &beacon.Container{
+		ID:       "1",
+		Service:  "example",
+		Labels:   map[string]string{"color": "red"},
+		Bindings: []*beacon.Binding{},
+	}
+	go func() {
+		runtime.Events <- &beacon.Event{Action: beacon.Start, Container: container}
+	}()
+	if _, err := backend.WaitForEvents(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ContainerSetsEqual(ReadState(t, path), []*beacon.Container{container}); err != nil {
+		t.Error(err)
+	}
+
+	if err := bcn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+// SyncBackend records the containers it is synced with.
+type SyncBackend struct {
+	*MockBackend
+	Syncs chan []*beacon.Container
+}
+
+func NewSyncBackend() *SyncBackend {
+	return &SyncBackend{
+		MockBackend: NewBackend(),
+		Syncs:       make(chan []*beacon.Container),
+	}
+}
+
+// Sync adds the containers to the backend.
+func (b *SyncBackend) Sync(containers []*beacon.Container) error {
+	b.Syncs <- containers
+	return nil
+}
+
+func (b *SyncBackend) WaitForSync(timeout time.Duration) ([]*beacon.Container, error) {
+	select {
+	case containers := <-b.Syncs:
+		return containers, nil
+	case <-time.After(timeout):
+		return nil, errors.New("timed out waiting for sync")
+	}
+}
+
+func TestBeaconStateSync(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	red := &beacon.Container{ID: "1", Service: "example", Labels: map[string]string{"color": "red"}, Bindings: []*beacon.Binding{}}
+	blue := &beacon.Container{ID: "2", Service: "example", Labels: map[string]string{"color": "blue"}, Bindings: []*beacon.Binding{}}
+	stopped := &beacon.Container{ID: "3", Service: "example", Labels: map[string]string{"color": "red"}, Bindings: []*beacon.Binding{}}
+	WriteState(t, path, []*beacon.Container{red, stopped})
+
+	runtime := NewRuntime()
+	backend := NewSyncBackend()
+	route := beacon.NewRoute(beacon.NewFilter(map[string]string{"color": "red"}), backend)
+	bcn, err := beacon.NewWithState(runtime, []beacon.Route{route}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bcn.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	go func() {
+		runtime.Events <- &beacon.Event{Action: beacon.Start, Container: red.Copy()}
+		runtime.Events <- &beacon.Event{Action: beacon.Start, Container: blue.Copy()}
+		runtime.Events <- &beacon.Event{Action: beacon.Synced}
+	}()
+
+	// the route is synced after the saved container is stopped
+	haveEvents, err := backend.WaitForEvents(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EventArraysEqual(haveEvents, []*beacon.Event{{Action: beacon.Stop, Container: stopped}}); err != nil {
+		t.Error(err)
+	}
+	haveContainers, err := backend.WaitForSync(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ContainerSetsEqual(haveContainers, []*beacon.Container{red}); err != nil {
+		t.Error(err)
+	}
+
+	// routes added once the runtime has synced are synced after their starts
+	added := NewSyncBackend()
+	addedRoute, err := beacon.NewQueue(beacon.NewRoute(nil, added), beacon.QueueConfig{Name: "added"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bcn.AddRoute(addedRoute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := added.WaitForEvents(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	haveContainers, err = added.WaitForSync(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ContainerSetsEqual(haveContainers, []*beacon.Container{red, blue}); err != nil {
+		t.Error(err)
+	}
+
+	if err := bcn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
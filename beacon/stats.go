@@ -0,0 +1,134 @@
+package beacon
+
+import (
+	"github.com/BlueDragonX/beacon/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync/atomic"
+	"time"
+)
+
+// RouteStats are the delivery statistics for a route.
+type RouteStats struct {
+	// The name of the route.
+	Name string
+
+	// The number of events waiting for delivery.
+	Queued int
+
+	// The number of events accepted by the route.
+	Routed uint64
+
+	// The number of events delivered to the backend.
+	Delivered uint64
+
+	// The number of failed delivery attempts which were retried.
+	Retried uint64
+
+	// The number of events which could not be delivered and were dead
+	// lettered or discarded.
+	Failed uint64
+
+	// The number of events dropped because the queue was full.
+	Dropped uint64
+}
+
+// routeStats counts events on a route and mirrors the counts to the route's
+// Prometheus metrics. The counters are updated atomically and must stay 64-bit
+// aligned.
+type routeStats struct {
+	routed    uint64
+	delivered uint64
+	retried   uint64
+	failed    uint64
+	dropped   uint64
+	metrics   routeMetrics
+}
+
+// routeMetrics are the Prometheus metrics for a route.
+type routeMetrics struct {
+	routed    prometheus.Counter
+	delivered prometheus.Counter
+	retried   prometheus.Counter
+	failed    prometheus.Counter
+	dropped   prometheus.Counter
+	depth     prometheus.Gauge
+	latency   prometheus.Observer
+}
+
+// newRouteStats creates the stats for the named route.
+func newRouteStats(name string) routeStats {
+	return routeStats{
+		metrics: routeMetrics{
+			routed:    metrics.Routed.WithLabelValues(name),
+			delivered: metrics.Deliveries.WithLabelValues(name, "success"),
+			retried:   metrics.Retries.WithLabelValues(name),
+			failed:    metrics.Deliveries.WithLabelValues(name, "failure"),
+			dropped:   metrics.Dropped.WithLabelValues(name),
+			depth:     metrics.QueueDepth.WithLabelValues(name),
+			latency:   metrics.Latency.WithLabelValues(name),
+		},
+	}
+}
+
+// deleteRouteMetrics removes the Prometheus metrics of the named route so that
+// a removed route is no longer reported.
+func deleteRouteMetrics(name string) {
+	metrics.Routed.DeleteLabelValues(name)
+	metrics.Deliveries.DeleteLabelValues(name, "success")
+	metrics.Deliveries.DeleteLabelValues(name, "failure")
+	metrics.Retries.DeleteLabelValues(name)
+	metrics.Dropped.DeleteLabelValues(name)
+	metrics.QueueDepth.DeleteLabelValues(name)
+	metrics.Latency.DeleteLabelValues(name)
+}
+
+// addRouted counts an event accepted by the route.
+func (s *routeStats) addRouted() {
+	atomic.AddUint64(&s.routed, 1)
+	s.metrics.routed.Inc()
+}
+
+// addDelivered counts an event delivered to the backend.
+func (s *routeStats) addDelivered() {
+	atomic.AddUint64(&s.delivered, 1)
+	s.metrics.delivered.Inc()
+}
+
+// addRetried counts a delivery attempt which will be retried.
+func (s *routeStats) addRetried() {
+	atomic.AddUint64(&s.retried, 1)
+	s.metrics.retried.Inc()
+}
+
+// addFailed counts an event which could not be delivered.
+func (s *routeStats) addFailed() {
+	atomic.AddUint64(&s.failed, 1)
+	s.metrics.failed.Inc()
+}
+
+// addDropped counts an event dropped from a full queue.
+func (s *routeStats) addDropped() {
+	atomic.AddUint64(&s.dropped, 1)
+	s.metrics.dropped.Inc()
+}
+
+// setDepth records the number of queued events.
+func (s *routeStats) setDepth(depth int) {
+	s.metrics.depth.Set(float64(depth))
+}
+
+// observe records how long a delivery attempt took.
+func (s *routeStats) observe(latency time.Duration) {
+	s.metrics.latency.Observe(latency.Seconds())
+}
+
+// get returns a snapshot of the counters.
+func (s *routeStats) get() RouteStats {
+	return RouteStats{
+		Routed:    atomic.LoadUint64(&s.routed),
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Retried:   atomic.LoadUint64(&s.retried),
+		Failed:    atomic.LoadUint64(&s.failed),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+	}
+}
@@ -0,0 +1,81 @@
+package beacon_test
+
+import (
+	beacon "."
+	"github.com/pkg/errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// WaitForRoutes waits up to `timeout` for the beacon's route stats to equal
+// `want`.
+func WaitForRoutes(t *testing.T, bcn beacon.Beacon, want []*beacon.RouteStats, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		have := bcn.Routes()
+		if reflect.DeepEqual(have, want) {
+			return
+		} else if time.Now().After(deadline) {
+			for n := range have {
+				t.Errorf("route %d stats are %+v", n, *have[n])
+			}
+			t.Fatal("timed out waiting for route stats")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBeaconRoutes(t *testing.T) {
+	t.Parallel()
+	retry := beacon.RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	okRoute, err := beacon.NewQueue(
+		beacon.NewRoute(nil, NewFailingBackend(1, errors.New("transient"))),
+		beacon.QueueConfig{Name: "ok", Retry: retry},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failRoute, err := beacon.NewQueue(
+		beacon.NewRoute(nil, NewFailingBackend(1, beacon.Permanent(errors.New("rejected")))),
+		beacon.QueueConfig{Name: "fail", Retry: retry},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := NewRuntime()
+	bcn, err := beacon.New(runtime, []beacon.Route{okRoute, failRoute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bcn.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if bcn.Ready() {
+		t.Error("beacon ready before sync")
+	}
+	runtime.Events <- &beacon.Event{Action: beacon.Start, Container: QueueEvent("1").Container}
+	runtime.Events <- &beacon.Event{Action: beacon.Synced}
+
+	WaitForRoutes(t, bcn, []*beacon.RouteStats{
+		{Name: "ok", Routed: 1, Delivered: 1, Retried: 1},
+		{Name: "fail", Routed: 1, Failed: 1},
+	}, 5*time.Second)
+
+	if err := bcn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if !bcn.Ready() {
+		t.Error("beacon not ready after sync")
+	}
+}
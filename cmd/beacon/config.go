@@ -2,10 +2,16 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/BlueDragonX/beacon/sns"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
@@ -20,19 +26,36 @@ const (
 
 	// DefaultDockerStopOnExit is used if no docker.stop-on-exit is set.
 	DefaultDockerStopOnExit = false
+
+	// DefaultContainerdSocket is used if no containerd.socket is set.
+	DefaultContainerdSocket = "/run/containerd/containerd.sock"
+
+	// DefaultContainerdNamespace is used if no containerd.namespace is set.
+	DefaultContainerdNamespace = "default"
+
+	// DefaultFileInterval is used if no file.interval is set.
+	DefaultFileInterval = 5 * time.Second
+
+	// DefaultEtcdPrefix is used if no etcd.prefix is set.
+	DefaultEtcdPrefix = "/beacon"
 )
 
 const (
-	envDockerSocket = "DOCKER_HOST"
-	envDockerHostIP = "DOCKER_IP"
+	envDockerSocket   = "DOCKER_HOST"
+	envDockerHostIP   = "DOCKER_IP"
+	envKubernetesNode = "NODE_NAME"
 )
 
 // Docker runtime configuration.
 type Docker struct {
-	Socket     string
-	HostIP     string `yaml:"host-ip"`
-	Label      string
-	StopOnExit bool `yaml:"stop-on-exit"`
+	Socket              string
+	HostIP              string `yaml:"host-ip"`
+	HostIPv6            string `yaml:"host-ipv6"`
+	HostInterface       string `yaml:"host-interface"`
+	Label               string
+	StopOnExit          bool `yaml:"stop-on-exit"`
+	WaitForHealthy      bool `yaml:"wait-for-healthy"`
+	ExcludeUnnamedPorts bool `yaml:"exclude-unnamed-ports"`
 }
 
 // Validate the docker configuration.
@@ -46,12 +69,168 @@ func (c *Docker) Validate() error {
 	if c.HostIP == "" {
 		return errors.New("Docker.HostIP may not be empty")
 	}
+	if err := validateHostIP(c.HostIP, false); err != nil {
+		return errors.Wrap(err, "invalid Docker.HostIP")
+	}
+	if c.HostIPv6 != "" {
+		if err := validateHostIP(c.HostIPv6, true); err != nil {
+			return errors.Wrap(err, "invalid Docker.HostIPv6")
+		}
+	}
 	if c.Label == "" {
 		return errors.New("Docker.Label may not be empty")
 	}
 	return nil
 }
 
+// validateHostIP checks that a host IP is `auto`, `ec2` or an address of the
+// right family.
+func validateHostIP(hostIP string, ipv6 bool) error {
+	if hostIP == "auto" || hostIP == "ec2" {
+		return nil
+	}
+	ip := net.ParseIP(hostIP)
+	if ip == nil {
+		return errors.Errorf("%s is not an IP address", hostIP)
+	} else if (ip.To4() == nil) != ipv6 {
+		return errors.Errorf("%s has the wrong address family", hostIP)
+	}
+	return nil
+}
+
+// Containerd runtime configuration.
+type Containerd struct {
+	Socket    string
+	Namespace string
+	Label     string
+}
+
+// Validate the containerd configuration.
+func (c *Containerd) Validate() error {
+	if c.Socket == "" {
+		return errors.New("Containerd.Socket may not be empty")
+	}
+	if c.Namespace == "" {
+		return errors.New("Containerd.Namespace may not be empty")
+	}
+	if c.Label == "" {
+		return errors.New("Containerd.Label may not be empty")
+	}
+	return nil
+}
+
+// File runtime configuration.
+type File struct {
+	Path     string
+	Interval time.Duration
+}
+
+// Validate the file configuration.
+func (c *File) Validate() error {
+	if c.Path == "" {
+		return errors.New("File.Path may not be empty")
+	}
+	if c.Interval <= 0 {
+		return errors.New("File.Interval must be positive")
+	}
+	return nil
+}
+
+// Kubernetes runtime configuration.
+type Kubernetes struct {
+	Kubeconfig string
+	Node       string
+	Label      string
+	Annotation string
+}
+
+// Validate the Kubernetes configuration.
+func (c *Kubernetes) Validate() error {
+	if c.Node == "" {
+		return errors.New("Kubernetes.Node may not be empty")
+	}
+	if c.Label == "" && c.Annotation == "" {
+		return errors.New("one of Kubernetes.Label or Kubernetes.Annotation is required")
+	}
+	return nil
+}
+
+// Runtime configures a source of container events. Exactly one of its
+// runtime fields should be set.
+type Runtime struct {
+	Name       string
+	Docker     *Docker
+	Containerd *Containerd
+	File       *File
+	Kubernetes *Kubernetes
+}
+
+// Kind returns the name of the configured runtime type.
+func (c *Runtime) Kind() string {
+	if c.Docker != nil {
+		return "docker"
+	} else if c.Containerd != nil {
+		return "containerd"
+	} else if c.File != nil {
+		return "file"
+	} else if c.Kubernetes != nil {
+		return "kubernetes"
+	}
+	return ""
+}
+
+// setDefaults fills in the default values of the configured runtime.
+func (c *Runtime) setDefaults() {
+	if c.Docker != nil {
+		if c.Docker.Socket == "" {
+			c.Docker.Socket = DefaultDockerSocket
+		}
+		if c.Docker.HostIP == "" {
+			c.Docker.HostIP = DefaultDockerHostIP
+		}
+	}
+	if c.Containerd != nil {
+		if c.Containerd.Socket == "" {
+			c.Containerd.Socket = DefaultContainerdSocket
+		}
+		if c.Containerd.Namespace == "" {
+			c.Containerd.Namespace = DefaultContainerdNamespace
+		}
+	}
+	if c.File != nil && c.File.Interval == 0 {
+		c.File.Interval = DefaultFileInterval
+	}
+	if c.Kubernetes != nil && c.Kubernetes.Node == "" {
+		c.Kubernetes.Node = os.Getenv(envKubernetesNode)
+	}
+}
+
+// Validate the runtime configuration.
+func (c *Runtime) Validate() error {
+	configured := 0
+	for _, set := range []bool{c.Docker != nil, c.Containerd != nil, c.File != nil, c.Kubernetes != nil} {
+		if set {
+			configured++
+		}
+	}
+	if configured != 1 {
+		return errors.New("exactly one of Docker, Containerd, File or Kubernetes must be configured")
+	}
+	if strings.Contains(c.Name, "/") {
+		return errors.Errorf("Runtime.Name %s may not contain a slash", c.Name)
+	}
+	switch {
+	case c.Docker != nil:
+		return c.Docker.Validate()
+	case c.Containerd != nil:
+		return c.Containerd.Validate()
+	case c.File != nil:
+		return c.File.Validate()
+	default:
+		return c.Kubernetes.Validate()
+	}
+}
+
 // Debug backend configuration.
 type Debug struct{}
 
@@ -62,8 +241,10 @@ func (c *Debug) Validate() error {
 
 // SNS backend configuration.
 type SNS struct {
-	Region string
-	Topic  string
+	Region  string
+	Topic   string
+	Subject string
+	Labels  []string
 }
 
 // Validate the SNS configuration.
@@ -77,30 +258,318 @@ func (c *SNS) Validate() error {
 	if c.Topic == "" {
 		return errors.New("SNS.Topic may not be empty")
 	}
+	if len(c.Labels) > sns.MaxLabels {
+		return errors.Errorf("SNS.Labels may not have more than %d labels", sns.MaxLabels)
+	}
 	return nil
 }
 
-// Backend configuration object.
-type Backend struct {
-	Debug  *Debug
-	SNS    *SNS
-	Filter map[string]string
+// SQS backend configuration.
+type SQS struct {
+	Region     string
+	Queue      string
+	BatchDelay time.Duration `yaml:"batch-delay"`
 }
 
-// Validate the backend configuration.
-func (c *Backend) Validate() error {
+// Validate the SQS configuration.
+func (c *SQS) Validate() error {
+	if c.Region == "" {
+		return errors.New("SQS.Region may not be empty")
+	}
+	if c.Queue == "" {
+		return errors.New("SQS.Queue may not be empty")
+	}
+	if c.BatchDelay < 0 {
+		return errors.New("SQS.BatchDelay may not be negative")
+	}
+	return nil
+}
+
+// Webhook backend configuration.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Secret  string
+	Timeout time.Duration
+	CA      string
+	Cert    string
+	Key     string
+}
+
+// Validate the webhook configuration.
+func (c *Webhook) Validate() error {
+	if c.URL == "" {
+		return errors.New("Webhook.URL may not be empty")
+	}
+	if c.Timeout < 0 {
+		return errors.New("Webhook.Timeout may not be negative")
+	}
+	if (c.Cert == "") != (c.Key == "") {
+		return errors.New("Webhook.Cert and Webhook.Key must be set together")
+	}
+	return nil
+}
+
+// Consul backend configuration.
+type Consul struct {
+	Address       string
+	Token         string
+	CheckInterval time.Duration `yaml:"check-interval"`
+}
+
+// Validate the Consul configuration.
+func (c *Consul) Validate() error {
+	if c.CheckInterval < 0 {
+		return errors.New("Consul.CheckInterval may not be negative")
+	}
+	return nil
+}
+
+// Etcd backend configuration.
+type Etcd struct {
+	Endpoints []string
+	Prefix    string
+	TTL       time.Duration
+}
+
+// Validate the etcd configuration.
+func (c *Etcd) Validate() error {
+	if len(c.Endpoints) == 0 {
+		return errors.New("Etcd.Endpoints may not be empty")
+	}
+	if !strings.HasPrefix(c.Prefix, "/") {
+		return errors.New("Etcd.Prefix must start with /")
+	}
+	if c.TTL != 0 && c.TTL < time.Second {
+		return errors.New("Etcd.TTL must be at least 1s")
+	}
+	return nil
+}
+
+// Filter configuration for a backend. The filter is either an expression or a
+// map of labels which containers must have.
+type Filter struct {
+	Expression string
+	Labels     map[string]string
+}
+
+// UnmarshalYAML reads the filter from either a string or a map.
+func (c *Filter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Expression); err == nil {
+		return nil
+	}
+	c.Expression = ""
+	return unmarshal(&c.Labels)
+}
+
+// Validate the filter configuration.
+func (c *Filter) Validate() error {
+	if c.Expression != "" {
+		if _, err := beacon.ParseFilter(c.Expression); err != nil {
+			return errors.Wrap(err, "Filter is invalid")
+		}
+	}
+	return nil
+}
+
+// Queue configuration for a backend.
+type Queue struct {
+	Size     int
+	Overflow string
+	Timeout  time.Duration
+}
+
+// Validate the queue configuration.
+func (c *Queue) Validate() error {
+	if c.Size < 0 {
+		return errors.New("Queue.Size may not be negative")
+	}
+	switch c.Overflow {
+	case "", "block", "drop-oldest", "drop-newest":
+	default:
+		return errors.Errorf("Queue.Overflow %s is invalid", c.Overflow)
+	}
+	if c.Timeout < 0 {
+		return errors.New("Queue.Timeout may not be negative")
+	}
+	return nil
+}
+
+// Retry configuration for a backend.
+type Retry struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration `yaml:"max-backoff"`
+	Jitter     float64
+}
+
+// Validate the retry configuration.
+func (c *Retry) Validate() error {
+	if c.Attempts < 0 {
+		return errors.New("Retry.Attempts may not be negative")
+	}
+	if c.Backoff < 0 {
+		return errors.New("Retry.Backoff may not be negative")
+	}
+	if c.MaxBackoff < 0 {
+		return errors.New("Retry.MaxBackoff may not be negative")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return errors.New("Retry.Jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Sink configures a destination for events. Exactly one of its fields should
+// be set.
+type Sink struct {
+	Consul  *Consul
+	Debug   *Debug
+	Etcd    *Etcd
+	SNS     *SNS
+	SQS     *SQS
+	Webhook *Webhook
+}
+
+// Kind returns the name of the configured sink type.
+func (c *Sink) Kind() string {
+	if c.SNS != nil {
+		return "sns"
+	} else if c.SQS != nil {
+		return "sqs"
+	} else if c.Webhook != nil {
+		return "webhook"
+	} else if c.Consul != nil {
+		return "consul"
+	} else if c.Etcd != nil {
+		return "etcd"
+	} else if c.Debug != nil {
+		return "debug"
+	}
+	return ""
+}
+
+// setDefaults fills in the sink settings which have defaults.
+func (c *Sink) setDefaults() {
+	if c.Etcd != nil && c.Etcd.Prefix == "" {
+		c.Etcd.Prefix = DefaultEtcdPrefix
+	}
+}
+
+// Validate the sink configuration.
+func (c *Sink) Validate() error {
 	if c.SNS != nil {
 		return c.SNS.Validate()
+	} else if c.SQS != nil {
+		return c.SQS.Validate()
+	} else if c.Webhook != nil {
+		return c.Webhook.Validate()
+	} else if c.Consul != nil {
+		return c.Consul.Validate()
+	} else if c.Etcd != nil {
+		return c.Etcd.Validate()
 	} else if c.Debug != nil {
 		return c.Debug.Validate()
 	}
 	return errors.New("backend not supported")
 }
 
+// DeadLetter configures where a backend sends events it fails to deliver. The
+// destination is either a file or another backend.
+type DeadLetter struct {
+	File string
+	Sink `yaml:",inline"`
+}
+
+// Validate the dead letter configuration.
+func (c *DeadLetter) Validate() error {
+	if c.File != "" {
+		if c.Kind() != "" {
+			return errors.New("DeadLetter may not have both a file and a backend")
+		}
+		return nil
+	}
+	return c.Sink.Validate()
+}
+
+// Backend configuration object.
+type Backend struct {
+	Sink       `yaml:",inline"`
+	Name       string
+	Filter     Filter
+	Actions    []string
+	Queue      Queue
+	Retry      Retry
+	DeadLetter *DeadLetter `yaml:"dead-letter"`
+	Spool      string
+}
+
+// Validate the backend configuration.
+func (c *Backend) Validate() error {
+	if err := c.Filter.Validate(); err != nil {
+		return err
+	}
+	for _, action := range c.Actions {
+		switch beacon.Action(action) {
+		case beacon.Start, beacon.Stop, beacon.Update, beacon.OOM:
+		default:
+			return errors.Errorf("Backend.Actions contains invalid action %s", action)
+		}
+	}
+	if err := c.Queue.Validate(); err != nil {
+		return err
+	}
+	if err := c.Retry.Validate(); err != nil {
+		return err
+	}
+	if c.DeadLetter != nil {
+		if err := c.DeadLetter.Validate(); err != nil {
+			return err
+		}
+	}
+	return c.Sink.Validate()
+}
+
+// HTTP configuration for the status API.
+type HTTP struct {
+	Listen string
+}
+
 // Config holds Beacon configuration.
 type Config struct {
-	Backends []Backend
-	Docker   Docker
+	Backends   []Backend
+	Runtimes   []Runtime
+	Docker     Docker
+	Containerd *Containerd
+	File       *File
+	Kubernetes *Kubernetes
+	HTTP       HTTP
+	StateFile  string `yaml:"state-file"`
+
+	// Path is the path to the config file. It is set from the command line.
+	Path string `yaml:"-"`
+
+	// Replay is the path to a dead letter file to replay. It is set from the
+	// command line.
+	Replay string `yaml:"-"`
+
+	// hasDocker is true if the config file has a top level Docker section.
+	hasDocker bool
+}
+
+// UnmarshalYAML reads the config and records whether the top level Docker
+// section is set, as its defaults make it impossible to tell otherwise.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type config Config
+	if err := unmarshal((*config)(c)); err != nil {
+		return err
+	}
+	sections := map[string]interface{}{}
+	if err := unmarshal(&sections); err != nil {
+		return err
+	}
+	_, c.hasDocker = sections["docker"]
+	return nil
 }
 
 // Validate the Beacon configuration.
@@ -108,20 +577,61 @@ func (c *Config) Validate() error {
 	if c == nil {
 		return errors.New("nil config object")
 	}
-	if err := c.Docker.Validate(); err != nil {
+	if len(c.Runtimes) > 0 {
+		if c.hasDocker || c.Containerd != nil || c.File != nil || c.Kubernetes != nil {
+			return errors.New("Runtimes may not be combined with Docker, Containerd, File or Kubernetes")
+		}
+		names := map[string]struct{}{}
+		for _, runtime := range c.Runtimes {
+			if err := runtime.Validate(); err != nil {
+				return err
+			}
+			if _, ok := names[runtime.Name]; ok {
+				return errors.Errorf("Runtime.Name %s is not unique", runtime.Name)
+			}
+			names[runtime.Name] = struct{}{}
+		}
+	} else if err := c.Runtime().Validate(); err != nil {
 		return err
 	}
 	if len(c.Backends) == 0 {
 		return errors.New("no backends configured")
 	}
+	names := map[string]struct{}{}
+	spools := map[string]struct{}{}
 	for _, backend := range c.Backends {
 		if err := backend.Validate(); err != nil {
 			return err
 		}
+		if _, ok := names[backend.Name]; ok {
+			return errors.Errorf("Backend.Name %s is not unique", backend.Name)
+		}
+		names[backend.Name] = struct{}{}
+		if backend.Spool != "" {
+			if _, ok := spools[backend.Spool]; ok {
+				return errors.Errorf("Backend.Spool %s is not unique", backend.Spool)
+			}
+			spools[backend.Spool] = struct{}{}
+		}
 	}
 	return nil
 }
 
+// Runtime returns the runtime configured by the top level Docker,
+// Containerd, File and Kubernetes sections. Docker is used if none of the
+// others are set. This is used when no Runtimes are configured.
+func (c *Config) Runtime() *Runtime {
+	runtime := &Runtime{
+		Containerd: c.Containerd,
+		File:       c.File,
+		Kubernetes: c.Kubernetes,
+	}
+	if runtime.Kind() == "" {
+		runtime.Docker = &c.Docker
+	}
+	return runtime
+}
+
 // DefaultConfig generates a default configuration.
 func DefaultConfig() *Config {
 	return &Config{
@@ -134,29 +644,55 @@ func DefaultConfig() *Config {
 	}
 }
 
+// LoadConfig reads and validates the config file at `path`.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config %s", path)
+	}
+
+	config := DefaultConfig()
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config %s", path)
+	}
+	for n := range config.Backends {
+		config.Backends[n].Sink.setDefaults()
+		if config.Backends[n].DeadLetter != nil {
+			config.Backends[n].DeadLetter.Sink.setDefaults()
+		}
+		if config.Backends[n].Name == "" {
+			config.Backends[n].Name = fmt.Sprintf("%s-%d", config.Backends[n].Kind(), n)
+		}
+	}
+	for n := range config.Runtimes {
+		config.Runtimes[n].setDefaults()
+		if config.Runtimes[n].Name == "" {
+			config.Runtimes[n].Name = fmt.Sprintf("%s-%d", config.Runtimes[n].Kind(), n)
+		}
+	}
+	config.Runtime().setDefaults()
+	config.Path = path
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "configuration invalid")
+	}
+	return config, nil
+}
+
 // Configure Beacon. Loads configuration into a Config.
 func Configure(args []string) *Config {
-	var path, dockerSocket, dockerHostIP string
+	var path, dockerSocket, dockerHostIP, replay string
 	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
 	flags.StringVar(&path, "config", DefaultConfigFile, "The path to the config file.")
+	flags.StringVar(&replay, "replay", "", "Replay the events in a dead letter file and exit.")
 	flags.StringVar(&dockerSocket, "docker-socket", DefaultDockerSocket, "The Docker socket to connect to.")
 	flags.StringVar(&dockerHostIP, "docker-host-ip", DefaultDockerHostIP, "The Docker host IP to advertise.")
 	flags.Parse(args[1:])
 
-	data, err := ioutil.ReadFile(path)
+	config, err := LoadConfig(path)
 	if err != nil {
-		Logger.Fatalf("failed to read config %s: %s\n", path, err)
-	}
-
-	config := DefaultConfig()
-	boop := map[interface{}]interface{}{}
-	yaml.Unmarshal(data, boop)
-	if err := yaml.Unmarshal(data, config); err != nil {
-		Logger.Fatalf("failed to parse config %s: %s\n", path, err)
-	}
-	if err := config.Validate(); err != nil {
-		Logger.Fatalf("configuration invalid: %s\n", err)
+		Logger.Fatal(err)
 	}
+	config.Replay = replay
 
 	// configure docker socket from cli/env
 	if os.Getenv(envDockerSocket) != "" {
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// loadConfig writes `data` to a temporary config file and loads it.
+func loadConfig(t *testing.T, data string) (*Config, error) {
+	tmp, err := ioutil.TempFile("", "beacon-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	return LoadConfig(tmp.Name())
+}
+
+func TestConfigRuntimes(t *testing.T) {
+	config, err := loadConfig(t, `
+runtimes:
+- docker:
+    label: service
+- name: vms
+  file:
+    path: /etc/beacon/vms.yml
+- docker:
+    socket: tcp://10.0.0.5:2375
+    label: service
+backends:
+- debug: {}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Runtimes) != 3 {
+		t.Fatalf("have %d runtimes, want 3", len(config.Runtimes))
+	}
+	wantNames := []string{"docker-0", "vms", "docker-2"}
+	for n, want := range wantNames {
+		if have := config.Runtimes[n].Name; have != want {
+			t.Errorf("runtime %d: have name %s, want %s", n, have, want)
+		}
+	}
+	if have := config.Runtimes[0].Docker.Socket; have != DefaultDockerSocket {
+		t.Errorf("have docker socket %s, want %s", have, DefaultDockerSocket)
+	}
+	if have := config.Runtimes[1].File.Interval; have != DefaultFileInterval {
+		t.Errorf("have file interval %s, want %s", have, DefaultFileInterval)
+	}
+	if have := config.Runtimes[2].Docker.Socket; have != "tcp://10.0.0.5:2375" {
+		t.Errorf("have docker socket %s, want tcp://10.0.0.5:2375", have)
+	}
+}
+
+func TestConfigRuntimesInvalid(t *testing.T) {
+	tests := []string{
+		// runtime names must be unique
+		`
+runtimes:
+- name: local
+  docker: {label: service}
+- name: local
+  file: {path: /etc/beacon/vms.yml}
+backends:
+- debug: {}
+`,
+		// each runtime has exactly one type
+		`
+runtimes:
+- docker: {label: service}
+  file: {path: /etc/beacon/vms.yml}
+backends:
+- debug: {}
+`,
+		// the list may not be combined with a top level runtime
+		`
+runtimes:
+- docker: {label: service}
+file:
+  path: /etc/beacon/vms.yml
+backends:
+- debug: {}
+`,
+		`
+runtimes:
+- file: {path: /etc/beacon/vms.yml}
+docker:
+  label: service
+backends:
+- debug: {}
+`,
+	}
+	for _, test := range tests {
+		if _, err := loadConfig(t, test); err == nil {
+			t.Errorf("config is valid:%s", test)
+		}
+	}
+}
+
+func TestConfigRuntime(t *testing.T) {
+	config, err := loadConfig(t, `
+file:
+  path: /etc/beacon/vms.yml
+  interval: 1s
+backends:
+- debug: {}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := config.Runtime()
+	if kind := runtime.Kind(); kind != "file" {
+		t.Errorf("have runtime %s, want file", kind)
+	}
+	if runtime.File.Interval != time.Second {
+		t.Errorf("have file interval %s, want 1s", runtime.File.Interval)
+	}
+}
+
+func TestConfigDockerHostIP(t *testing.T) {
+	tests := []struct {
+		config string
+		valid  bool
+	}{
+		{"host-ip: 10.0.0.5\n  host-ipv6: fd00::5", true},
+		{"host-ip: auto\n  host-ipv6: auto\n  host-interface: eth0", true},
+		{"host-ip: ec2", true},
+		{"host-ip: fd00::5", false},
+		{"host-ip: 10.0.0.5\n  host-ipv6: 10.0.0.6", false},
+		{"host-ip: eth0", false},
+	}
+	for _, test := range tests {
+		_, err := loadConfig(t, "docker:\n  label: service\n  "+test.config+"\nbackends:\n- debug: {}\n")
+		if test.valid && err != nil {
+			t.Errorf("%q: %s", test.config, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%q: config is valid", test.config)
+		}
+	}
+}
+
+func TestConfigWebhook(t *testing.T) {
+	config, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- webhook:
+    url: https://hooks.example.com/beacon
+    headers:
+      Authorization: Bearer token
+    secret: secret
+    timeout: 5s
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := config.Backends[0].Webhook
+	if kind := config.Backends[0].Kind(); kind != "webhook" {
+		t.Errorf("have backend %s, want webhook", kind)
+	}
+	if hook.Headers["Authorization"] != "Bearer token" || hook.Timeout != 5*time.Second {
+		t.Errorf("have webhook config %+v", hook)
+	}
+
+	if _, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- webhook:
+    url: https://hooks.example.com/beacon
+    cert: /etc/beacon/client.crt
+`); err == nil {
+		t.Error("webhook with cert and no key is valid")
+	}
+}
+
+func TestConfigSNS(t *testing.T) {
+	config, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- sns:
+    region: us-east-1
+    topic: arn:aws:sns:us-east-1:698519295917:TestTopic.fifo
+    subject: beacon
+    labels: [group, env]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := config.Backends[0].SNS
+	if c.Subject != "beacon" || len(c.Labels) != 2 || c.Labels[0] != "group" || c.Labels[1] != "env" {
+		t.Errorf("have sns config %+v", c)
+	}
+
+	if _, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- sns:
+    region: us-east-1
+    topic: arn:aws:sns:us-east-1:698519295917:TestTopic
+    labels: [a, b, c, d, e, f, g, h]
+`); err == nil {
+		t.Error("sns with eight labels is valid")
+	}
+}
+
+func TestConfigSQS(t *testing.T) {
+	config, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- sqs:
+    region: us-east-1
+    queue: https://sqs.us-east-1.amazonaws.com/698519295917/TestQueue.fifo
+    batch-delay: 100ms
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind := config.Backends[0].Kind(); kind != "sqs" {
+		t.Errorf("have backend %s, want sqs", kind)
+	}
+	if delay := config.Backends[0].SQS.BatchDelay; delay != 100*time.Millisecond {
+		t.Errorf("have batch delay %s, want 100ms", delay)
+	}
+
+	if _, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- sqs:
+    region: us-east-1
+`); err == nil {
+		t.Error("sqs with no queue is valid")
+	}
+}
+
+func TestConfigConsul(t *testing.T) {
+	config, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- consul:
+    check-interval: 10s
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := config.Backends[0].Consul
+	if c.CheckInterval != 10*time.Second {
+		t.Errorf("have check interval %s, want 10s", c.CheckInterval)
+	}
+}
+
+func TestConfigEtcd(t *testing.T) {
+	config, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- etcd:
+    endpoints: [http://127.0.0.1:2379]
+    ttl: 10s
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := config.Backends[0].Etcd
+	if kind := config.Backends[0].Kind(); kind != "etcd" {
+		t.Errorf("have backend %s, want etcd", kind)
+	}
+	if c.Prefix != DefaultEtcdPrefix || c.TTL != 10*time.Second {
+		t.Errorf("have etcd config %+v", c)
+	}
+
+	if _, err := loadConfig(t, `
+docker:
+  label: service
+backends:
+- etcd:
+    endpoints: [http://127.0.0.1:2379]
+    prefix: beacon
+`); err == nil {
+		t.Error("etcd with relative prefix is valid")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/BlueDragonX/beacon/metrics"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// NewHandler creates the HTTP handler for Beacon's status API.
+func NewHandler(bcn beacon.Beacon) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers", func(w http.ResponseWriter, r *http.Request) {
+		filter, err := beacon.ParseContainerFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		containers := bcn.Containers(filter)
+		sortContainers(containers)
+		writeJSON(w, http.StatusOK, containers)
+	})
+	mux.HandleFunc("/services", func(w http.ResponseWriter, r *http.Request) {
+		containers := bcn.Containers(nil)
+		sortContainers(containers)
+		services := map[string][]*beacon.Container{}
+		for _, container := range containers {
+			services[container.Service] = append(services[container.Service], container)
+		}
+		writeJSON(w, http.StatusOK, services)
+	})
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, bcn.Routes())
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if bcn.Ready() {
+			writeStatus(w, http.StatusOK, "ready")
+		} else {
+			writeStatus(w, http.StatusServiceUnavailable, "not ready")
+		}
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// ServeHTTP listens on `addr` and serves the status API in the background.
+// The returned server should be closed on shutdown.
+func ServeHTTP(addr string, bcn beacon.Beacon) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: NewHandler(bcn)}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			Logger.Printf("http server failed: %s", err)
+		}
+	}()
+	return server, nil
+}
+
+// sortContainers sorts containers by ID so responses are stable.
+func sortContainers(containers []*beacon.Container) {
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].ID < containers[j].ID
+	})
+}
+
+// writeJSON writes `value` to the response as JSON.
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		Logger.Printf("failed to write response: %s", err)
+	}
+}
+
+// writeStatus writes a JSON status message to the response.
+func writeStatus(w http.ResponseWriter, code int, status string) {
+	writeJSON(w, code, map[string]string{"status": status})
+}
+
+// writeError writes a JSON error message to the response.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
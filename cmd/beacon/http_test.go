@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BlueDragonX/beacon/beacon"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// FakeBeacon serves fixed containers and route stats.
+type FakeBeacon struct {
+	containers []*beacon.Container
+	routes     []*beacon.RouteStats
+	ready      bool
+}
+
+func (b *FakeBeacon) Run() error {
+	return nil
+}
+
+func (b *FakeBeacon) Close() error {
+	return nil
+}
+
+func (b *FakeBeacon) Containers(filter beacon.Filter) []*beacon.Container {
+	containers := []*beacon.Container{}
+	for _, container := range b.containers {
+		if filter == nil || filter.MatchContainer(container) {
+			containers = append(containers, container)
+		}
+	}
+	return containers
+}
+
+func (b *FakeBeacon) Routes() []*beacon.RouteStats {
+	return b.routes
+}
+
+func (b *FakeBeacon) Ready() bool {
+	return b.ready
+}
+
+func (b *FakeBeacon) AddRoute(route beacon.Route) error {
+	return route.Close()
+}
+
+func (b *FakeBeacon) RemoveRoute(name string) error {
+	return nil
+}
+
+func (b *FakeBeacon) UpdateFilter(name string, filter beacon.Filter) error {
+	return nil
+}
+
+func NewFakeBeacon() *FakeBeacon {
+	return &FakeBeacon{
+		containers: []*beacon.Container{
+			{ID: "2", Service: "www", Labels: map[string]string{"env": "prod"}},
+			{ID: "1", Service: "www", Labels: map[string]string{"env": "dev"}},
+			{ID: "3", Service: "db", Labels: map[string]string{"env": "prod"}},
+		},
+		routes: []*beacon.RouteStats{
+			{Name: "sns-0", Queued: 1, Routed: 3, Delivered: 2},
+		},
+	}
+}
+
+// get requests `path` from the handler and decodes the JSON response into
+// `value`.
+func get(t *testing.T, bcn beacon.Beacon, path string, value interface{}) int {
+	server := httptest.NewServer(NewHandler(bcn))
+	defer server.Close()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func containerIDs(containers []*beacon.Container) []string {
+	ids := []string{}
+	for _, container := range containers {
+		ids = append(ids, container.ID)
+	}
+	return ids
+}
+
+func TestHTTPContainers(t *testing.T) {
+	bcn := NewFakeBeacon()
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{"", []string{"1", "2", "3"}},
+		{"env=prod", []string{"2", "3"}},
+		{"@service == www && env == dev", []string{"1"}},
+	}
+	for _, test := range tests {
+		containers := []*beacon.Container{}
+		code := get(t, bcn, "/containers?filter="+url.QueryEscape(test.filter), &containers)
+		if code != http.StatusOK {
+			t.Errorf("filter %q: status %d, want %d", test.filter, code, http.StatusOK)
+		}
+		if have := containerIDs(containers); !reflect.DeepEqual(have, test.want) {
+			t.Errorf("filter %q: containers %v, want %v", test.filter, have, test.want)
+		}
+	}
+}
+
+func TestHTTPContainersBadFilter(t *testing.T) {
+	for _, filter := range []string{"env ==", "@action in (start, stop)"} {
+		body := map[string]string{}
+		code := get(t, NewFakeBeacon(), "/containers?filter="+url.QueryEscape(filter), &body)
+		if code != http.StatusBadRequest {
+			t.Errorf("filter %q: status %d, want %d", filter, code, http.StatusBadRequest)
+		}
+		if body["error"] == "" {
+			t.Errorf("filter %q: missing error message", filter)
+		}
+	}
+}
+
+func TestHTTPServices(t *testing.T) {
+	services := map[string][]*beacon.Container{}
+	if code := get(t, NewFakeBeacon(), "/services", &services); code != http.StatusOK {
+		t.Errorf("status %d, want %d", code, http.StatusOK)
+	}
+	want := map[string][]string{
+		"www": {"1", "2"},
+		"db":  {"3"},
+	}
+	have := map[string][]string{}
+	for service, containers := range services {
+		have[service] = containerIDs(containers)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("services %v, want %v", have, want)
+	}
+}
+
+func TestHTTPRoutes(t *testing.T) {
+	bcn := NewFakeBeacon()
+	routes := []*beacon.RouteStats{}
+	if code := get(t, bcn, "/routes", &routes); code != http.StatusOK {
+		t.Errorf("status %d, want %d", code, http.StatusOK)
+	}
+	if !reflect.DeepEqual(routes, bcn.routes) {
+		t.Errorf("routes %+v, want %+v", routes, bcn.routes)
+	}
+}
+
+func TestHTTPHealth(t *testing.T) {
+	bcn := NewFakeBeacon()
+	body := map[string]string{}
+	if code := get(t, bcn, "/healthz", &body); code != http.StatusOK {
+		t.Errorf("healthz status %d, want %d", code, http.StatusOK)
+	}
+	if code := get(t, bcn, "/readyz", &body); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz status %d before sync, want %d", code, http.StatusServiceUnavailable)
+	}
+	bcn.ready = true
+	if code := get(t, bcn, "/readyz", &body); code != http.StatusOK {
+		t.Errorf("readyz status %d after sync, want %d", code, http.StatusOK)
+	}
+}
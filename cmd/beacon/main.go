@@ -2,9 +2,16 @@ package main
 
 import (
 	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/BlueDragonX/beacon/consul"
+	"github.com/BlueDragonX/beacon/containerd"
 	"github.com/BlueDragonX/beacon/debug"
 	"github.com/BlueDragonX/beacon/docker"
+	"github.com/BlueDragonX/beacon/etcd"
+	"github.com/BlueDragonX/beacon/file"
+	"github.com/BlueDragonX/beacon/kubernetes"
 	"github.com/BlueDragonX/beacon/sns"
+	"github.com/BlueDragonX/beacon/sqs"
+	"github.com/BlueDragonX/beacon/webhook"
 	"github.com/pkg/errors"
 	"log"
 	"os"
@@ -15,47 +22,264 @@ var Logger = log.New(os.Stdout, "", 0)
 
 func init() {
 	beacon.Logger = Logger
+	consul.Logger = Logger
+	containerd.Logger = Logger
 	docker.Logger = Logger
+	etcd.Logger = Logger
+	file.Logger = Logger
+	kubernetes.Logger = Logger
+	sqs.Logger = Logger
 }
 
-// NewBeacon creates a new Beacon from configuration.
-func NewBeacon(config *Config) (beacon.Beacon, error) {
-	docker, err := docker.New(
-		config.Docker.Socket,
-		config.Docker.HostIP,
-		config.Docker.Label,
-		config.Docker.StopOnExit,
-	)
+// NewBackend creates a backend from a sink configuration.
+func NewBackend(config *Sink) (beacon.Backend, error) {
+	if config.SNS != nil {
+		return sns.New(
+			config.SNS.Region,
+			config.SNS.Topic,
+			config.SNS.Labels,
+			config.SNS.Subject,
+		), nil
+	} else if config.SQS != nil {
+		return sqs.New(
+			config.SQS.Region,
+			config.SQS.Queue,
+			config.SQS.BatchDelay,
+		), nil
+	} else if config.Webhook != nil {
+		tlsConfig, err := webhook.NewTLSConfig(config.Webhook.CA, config.Webhook.Cert, config.Webhook.Key)
+		if err != nil {
+			return nil, err
+		}
+		return webhook.New(
+			config.Webhook.URL,
+			config.Webhook.Headers,
+			config.Webhook.Secret,
+			config.Webhook.Timeout,
+			tlsConfig,
+		), nil
+	} else if config.Consul != nil {
+		return consul.New(
+			config.Consul.Address,
+			config.Consul.Token,
+			config.Consul.CheckInterval,
+		), nil
+	} else if config.Etcd != nil {
+		return etcd.New(
+			config.Etcd.Endpoints,
+			config.Etcd.Prefix,
+			config.Etcd.TTL,
+		)
+	} else if config.Debug != nil {
+		return debug.New(Logger), nil
+	}
+	return nil, errors.New("unsupported backend")
+}
+
+// NewFilter creates a filter from a backend's filter and action
+// configuration.
+func NewFilter(config *Filter, actions []string) (beacon.Filter, error) {
+	var filter beacon.Filter
+	if config.Expression != "" {
+		var err error
+		if filter, err = beacon.ParseFilter(config.Expression); err != nil {
+			return nil, err
+		}
+	} else {
+		filter = beacon.NewFilter(config.Labels)
+	}
+	if len(actions) == 0 {
+		return filter, nil
+	}
+
+	filterActions := make([]beacon.Action, len(actions))
+	for n, action := range actions {
+		filterActions[n] = beacon.Action(action)
+	}
+	return beacon.NewActionFilter(filter, filterActions), nil
+}
+
+// NewQueuedRoute creates the queued route for a backend.
+func NewQueuedRoute(config *Backend) (beacon.Route, error) {
+	filter, err := NewFilter(&config.Filter, config.Actions)
 	if err != nil {
 		return nil, err
 	}
 
-	routes := make([]beacon.Route, len(config.Backends))
-	for n, backendCfg := range config.Backends {
-		var backend beacon.Backend
-		filter := beacon.NewFilter(backendCfg.Filter)
-		if backendCfg.SNS != nil {
-			backend = sns.New(
-				backendCfg.SNS.Region,
-				backendCfg.SNS.Topic,
-			)
-		} else if backendCfg.Debug != nil {
-			backend = debug.New(Logger)
+	backend, err := NewBackend(&config.Sink)
+	if err != nil {
+		return nil, err
+	}
+
+	var deadLetter beacon.DeadLetterSink
+	if config.DeadLetter != nil {
+		if config.DeadLetter.File != "" {
+			deadLetter = beacon.NewDeadLetterFile(config.DeadLetter.File)
 		} else {
-			return nil, errors.New("unsupported backend")
+			deadLetterBackend, err := NewBackend(&config.DeadLetter.Sink)
+			if err != nil {
+				backend.Close()
+				return nil, err
+			}
+			deadLetter = beacon.NewDeadLetterBackend(deadLetterBackend)
+		}
+	}
+
+	route, err := beacon.NewQueue(beacon.NewRoute(filter, backend), beacon.QueueConfig{
+		Name:     config.Name,
+		Size:     config.Queue.Size,
+		Overflow: beacon.Overflow(config.Queue.Overflow),
+		Timeout:  config.Queue.Timeout,
+		Retry: beacon.RetryPolicy{
+			Attempts:   config.Retry.Attempts,
+			Backoff:    config.Retry.Backoff,
+			MaxBackoff: config.Retry.MaxBackoff,
+			Jitter:     config.Retry.Jitter,
+		},
+		DeadLetter: deadLetter,
+		Spool:      config.Spool,
+	})
+	if err != nil {
+		backend.Close()
+		if deadLetter != nil {
+			deadLetter.Close()
+		}
+		return nil, err
+	}
+	return route, nil
+}
+
+// NewRoutes creates the queued routes for each configured backend.
+func NewRoutes(config *Config) ([]beacon.Route, error) {
+	routes := make([]beacon.Route, 0, len(config.Backends))
+	for n := range config.Backends {
+		route, err := NewQueuedRoute(&config.Backends[n])
+		if err != nil {
+			for _, route := range routes {
+				route.Close()
+			}
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// NewRuntime creates a runtime from its configuration.
+func NewRuntime(config *Runtime) (beacon.Runtime, error) {
+	if config.Docker != nil {
+		hostIP, err := docker.ResolveHostIP(config.Docker.HostIP, config.Docker.HostInterface, false)
+		if err != nil {
+			return nil, err
+		}
+		hostIPv6 := ""
+		if config.Docker.HostIPv6 != "" {
+			if hostIPv6, err = docker.ResolveHostIP(config.Docker.HostIPv6, config.Docker.HostInterface, true); err != nil {
+				return nil, err
+			}
+		}
+		return docker.New(config.Docker.Socket, hostIP, config.Docker.Label, docker.Options{
+			HostIPv6:            hostIPv6,
+			StopOnClose:         config.Docker.StopOnExit,
+			WaitForHealthy:      config.Docker.WaitForHealthy,
+			ExcludeUnnamedPorts: config.Docker.ExcludeUnnamedPorts,
+		})
+	} else if config.Containerd != nil {
+		return containerd.New(
+			config.Containerd.Socket,
+			config.Containerd.Namespace,
+			config.Containerd.Label,
+		)
+	} else if config.File != nil {
+		return file.New(config.File.Path, config.File.Interval)
+	} else if config.Kubernetes != nil {
+		return kubernetes.New(
+			config.Kubernetes.Kubeconfig,
+			config.Kubernetes.Node,
+			config.Kubernetes.Label,
+			config.Kubernetes.Annotation,
+		)
+	}
+	return nil, errors.New("unsupported runtime")
+}
+
+// NewRuntimes creates the configured runtimes. Runtimes in the Runtimes list
+// are merged into a single runtime which prefixes container IDs with the
+// runtime name. Otherwise the single top level runtime is used as is.
+func NewRuntimes(config *Config) (beacon.Runtime, error) {
+	if len(config.Runtimes) == 0 {
+		return NewRuntime(config.Runtime())
+	}
+
+	runtimes := make(map[string]beacon.Runtime, len(config.Runtimes))
+	for n := range config.Runtimes {
+		runtime, err := NewRuntime(&config.Runtimes[n])
+		if err != nil {
+			for _, runtime := range runtimes {
+				runtime.Close()
+			}
+			return nil, errors.Wrapf(err, "failed to create runtime %s", config.Runtimes[n].Name)
 		}
-		routes[n] = beacon.NewRoute(filter, backend)
+		runtimes[config.Runtimes[n].Name] = runtime
 	}
-	return beacon.New(docker, routes)
+	return beacon.NewMultiRuntime(runtimes)
+}
+
+// NewBeacon creates a new Beacon from configuration.
+func NewBeacon(config *Config) (beacon.Beacon, error) {
+	runtime, err := NewRuntimes(config)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := NewRoutes(config)
+	if err != nil {
+		runtime.Close()
+		return nil, err
+	}
+	return beacon.NewWithState(runtime, routes, config.StateFile)
 }
 
 func main() {
 	config := Configure(os.Args)
+	if config.Replay != "" {
+		if err := Replay(config, config.Replay); err != nil {
+			Logger.Fatalf("failed to replay %s: %s", config.Replay, err)
+		}
+		return
+	}
+
 	bcn, err := NewBeacon(config)
 	if err != nil {
 		Logger.Fatalf("failed to initialize: %s", err)
 	}
 
+	if config.HTTP.Listen != "" {
+		server, err := ServeHTTP(config.HTTP.Listen, bcn)
+		if err != nil {
+			Logger.Fatalf("failed to listen on %s: %s", config.HTTP.Listen, err)
+		}
+		defer server.Close()
+	}
+
+	reloads := notifyOnReload()
+	go func() {
+		running := config
+		for range reloads {
+			Logger.Printf("reloading configuration from %s", running.Path)
+			reloaded, err := LoadConfig(running.Path)
+			if err != nil {
+				Logger.Printf("failed to reload configuration: %s", err)
+				continue
+			}
+			if err := Reload(bcn, running, reloaded); err != nil {
+				Logger.Printf("failed to reload configuration: %s", err)
+				continue
+			}
+			running = reloaded
+		}
+	}()
+
 	signals := notifyOnStop()
 
 	go func() {
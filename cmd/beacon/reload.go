@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	"reflect"
+)
+
+// Reload applies the backends in `config` to a running beacon. Backends are
+// matched by name against the `running` config and the beacon's routes. New
+// backends are added and are sent a Start event for each tracked container
+// they match. Backends which are no longer configured are removed. Backends
+// whose filter or actions changed have their filter replaced in place while
+// backends with any other change are removed and added again. Routes which
+// `running` does not describe, such as those added by a reload which failed,
+// are also removed and added again. The runtime and the beacon's container
+// state are left untouched.
+func Reload(bcn beacon.Beacon, running, config *Config) error {
+	routes := map[string]struct{}{}
+	for _, stats := range bcn.Routes() {
+		routes[stats.Name] = struct{}{}
+	}
+	current := map[string]*Backend{}
+	for name := range routes {
+		current[name] = nil
+	}
+	for n := range running.Backends {
+		if _, ok := routes[running.Backends[n].Name]; ok {
+			current[running.Backends[n].Name] = &running.Backends[n]
+		}
+	}
+
+	added := []*Backend{}
+	removed := []string{}
+	failed := 0
+	for n := range config.Backends {
+		backendCfg := &config.Backends[n]
+		old, ok := current[backendCfg.Name]
+		delete(current, backendCfg.Name)
+		if !ok {
+			added = append(added, backendCfg)
+		} else if old == nil || !sameRoute(old, backendCfg) {
+			removed = append(removed, backendCfg.Name)
+			added = append(added, backendCfg)
+		} else if !sameFilter(old, backendCfg) {
+			Logger.Printf("updating filter on backend %s", backendCfg.Name)
+			filter, err := NewFilter(&backendCfg.Filter, backendCfg.Actions)
+			if err == nil {
+				err = bcn.UpdateFilter(backendCfg.Name, filter)
+			}
+			if err != nil {
+				Logger.Printf("failed to update filter on backend %s: %s", backendCfg.Name, err)
+				failed++
+			}
+		}
+	}
+	for name := range current {
+		removed = append(removed, name)
+	}
+
+	// remove routes first so their spools are free to be reused
+	for _, name := range removed {
+		Logger.Printf("removing backend %s", name)
+		if err := bcn.RemoveRoute(name); err != nil {
+			Logger.Printf("failed to remove backend %s: %s", name, err)
+		}
+	}
+	for _, backendCfg := range added {
+		Logger.Printf("adding backend %s", backendCfg.Name)
+		route, err := NewQueuedRoute(backendCfg)
+		if err == nil {
+			err = bcn.AddRoute(route)
+		}
+		if err != nil {
+			Logger.Printf("failed to add backend %s: %s", backendCfg.Name, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d backends failed to reload", failed)
+	}
+	return nil
+}
+
+// sameRoute returns true if two backend configs differ only in their filters.
+func sameRoute(a, b *Backend) bool {
+	aCp, bCp := *a, *b
+	aCp.Filter, bCp.Filter = Filter{}, Filter{}
+	aCp.Actions, bCp.Actions = nil, nil
+	return reflect.DeepEqual(aCp, bCp)
+}
+
+// sameFilter returns true if two backend configs have the same filter.
+func sameFilter(a, b *Backend) bool {
+	return reflect.DeepEqual(a.Filter, b.Filter) && reflect.DeepEqual(a.Actions, b.Actions)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"github.com/BlueDragonX/beacon/beacon"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeRuntime emits the events sent to its channel.
+type FakeRuntime struct {
+	events chan *beacon.Event
+}
+
+func (r *FakeRuntime) EmitEvents() (<-chan *beacon.Event, error) {
+	return r.events, nil
+}
+
+func (r *FakeRuntime) Close() error {
+	close(r.events)
+	return nil
+}
+
+func debugBackend(name, filter string) Backend {
+	return Backend{
+		Sink:   Sink{Debug: &Debug{}},
+		Name:   name,
+		Filter: Filter{Expression: filter},
+	}
+}
+
+// waitForRouted waits for the beacon's routes to have routed the given number
+// of events.
+func waitForRouted(t *testing.T, bcn beacon.Beacon, want map[string]uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		have := map[string]uint64{}
+		for _, stats := range bcn.Routes() {
+			have[stats.Name] = stats.Routed
+		}
+		if reflect.DeepEqual(have, want) {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("routed events are %v, want %v", have, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// runReloadBeacon runs a beacon with the backends in `config`. The returned
+// function stops it.
+func runReloadBeacon(t *testing.T, config *Config) (beacon.Beacon, *FakeRuntime, func()) {
+	routes, err := NewRoutes(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := &FakeRuntime{events: make(chan *beacon.Event)}
+	bcn, err := beacon.New(runtime, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bcn.Run(); err != nil {
+			t.Error(err)
+		}
+	}()
+	return bcn, runtime, func() {
+		bcn.Close()
+		wg.Wait()
+	}
+}
+
+func TestReload(t *testing.T) {
+	running := &Config{
+		Backends: []Backend{
+			debugBackend("a", "color=red"),
+			debugBackend("b", "color=red"),
+			debugBackend("c", "color=red"),
+		},
+	}
+	bcn, runtime, stop := runReloadBeacon(t, running)
+	defer stop()
+
+	runtime.events <- &beacon.Event{
+		Action:    beacon.Start,
+		Container: &beacon.Container{ID: "1", Service: "www", Labels: map[string]string{"color": "red"}},
+	}
+	waitForRouted(t, bcn, map[string]uint64{"a": 1, "b": 1, "c": 1})
+
+	// a is removed, b has a new filter, c has a new queue size, d is new
+	reloaded := &Config{
+		Backends: []Backend{
+			debugBackend("b", "color=blue"),
+			debugBackend("c", "color=red"),
+			debugBackend("d", "color=red"),
+		},
+	}
+	reloaded.Backends[1].Queue.Size = 8
+	if err := Reload(bcn, running, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	waitForRouted(t, bcn, map[string]uint64{"b": 1, "c": 1, "d": 1})
+
+	runtime.events <- &beacon.Event{
+		Action:    beacon.Start,
+		Container: &beacon.Container{ID: "2", Service: "www", Labels: map[string]string{"color": "blue"}},
+	}
+	waitForRouted(t, bcn, map[string]uint64{"b": 2, "c": 1, "d": 1})
+}
+
+func TestReloadAfterFailure(t *testing.T) {
+	running := &Config{
+		Backends: []Backend{debugBackend("a", "color=red")},
+	}
+	bcn, _, stop := runReloadBeacon(t, running)
+	defer stop()
+
+	// b is added before c fails, the running config is kept
+	failed := &Config{
+		Backends: []Backend{
+			debugBackend("a", "color=red"),
+			debugBackend("b", "color=red"),
+			debugBackend("c", "color == ("),
+		},
+	}
+	if err := Reload(bcn, running, failed); err == nil {
+		t.Fatal("expected error reloading an invalid filter")
+	}
+
+	reloaded := &Config{
+		Backends: []Backend{
+			debugBackend("a", "color=red"),
+			debugBackend("b", "color=blue"),
+			debugBackend("c", "color=red"),
+		},
+	}
+	if err := Reload(bcn, running, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	waitForRouted(t, bcn, map[string]uint64{"a": 0, "b": 0, "c": 0})
+}
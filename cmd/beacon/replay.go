@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	"os"
+)
+
+// Replay sends the events in the dead letter file at `path` to the backends
+// they were originally routed to. The file is moved aside while it is
+// replayed so that events which fail again are dead lettered anew. Events for
+// backends which are no longer configured, and events which could not be
+// queued, are written back to `path`.
+//
+// Replay refuses to run if the file moved aside by an earlier replay still
+// exists, as that replay may not have finished. The replay routes are not
+// spooled so that they do not share a spool with a running Beacon.
+func Replay(config *Config, path string) error {
+	replayPath := path + ".replay"
+	if _, err := os.Stat(replayPath); err == nil {
+		return errors.Errorf("%s exists, an earlier replay may not have finished", replayPath)
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to check for %s", replayPath)
+	}
+
+	// create the routes before moving the file so that a bad backend config
+	// leaves it in place
+	routes := make(map[string]beacon.Route, len(config.Backends))
+	for _, backend := range config.Backends {
+		backend.Spool = ""
+		route, err := NewQueuedRoute(&backend)
+		if err != nil {
+			closeRoutes(routes)
+			return err
+		}
+		routes[backend.Name] = route
+	}
+
+	if err := os.Rename(path, replayPath); err != nil {
+		closeRoutes(routes)
+		return errors.Wrap(err, "failed to move dead letter file aside")
+	}
+	letters, err := beacon.ReadDeadLetters(replayPath)
+	if err != nil {
+		closeRoutes(routes)
+		if renameErr := os.Rename(replayPath, path); renameErr != nil {
+			Logger.Printf("failed to restore %s: %s", path, renameErr)
+		}
+		return err
+	}
+
+	kept := beacon.NewDeadLetterFile(path)
+	keep := func(letter *beacon.DeadLetter) error {
+		if err := kept.DeadLetter(letter); err != nil {
+			closeRoutes(routes)
+			return errors.Wrapf(err, "failed to keep event %s for container %s, the remaining events are in %s", letter.Event.Action, letter.Event.Container.ID, replayPath)
+		}
+		return nil
+	}
+	replayed := 0
+	for _, letter := range letters {
+		route, ok := routes[letter.Route]
+		if !ok {
+			Logger.Printf("backend %s not configured, keeping event %s for container %s", letter.Route, letter.Event.Action, letter.Event.Container.ID)
+			if err := keep(letter); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := route.ProcessEvent(letter.Event); err != nil {
+			Logger.Printf("failed to replay event %s for container %s, keeping it: %s", letter.Event.Action, letter.Event.Container.ID, err)
+			if err := keep(letter); err != nil {
+				return err
+			}
+			continue
+		}
+		replayed++
+	}
+
+	closeRoutes(routes)
+	Logger.Printf("replayed %d of %d events from %s", replayed, len(letters), path)
+	return os.Remove(replayPath)
+}
+
+// closeRoutes closes the routes, which waits for their queued events to be
+// delivered.
+func closeRoutes(routes map[string]beacon.Route) {
+	for name, route := range routes {
+		if err := route.Close(); err != nil {
+			Logger.Printf("failed to close backend %s: %s", name, err)
+		}
+	}
+}
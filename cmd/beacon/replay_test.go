@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BlueDragonX/beacon/beacon"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookServer records the container IDs of the events POSTed to it. Events for
+// containers in `reject` are answered with 400 Bad Request.
+type hookServer struct {
+	*httptest.Server
+	lock sync.Mutex
+	ids  []string
+}
+
+func newHookServer(reject ...string) *hookServer {
+	hook := &hookServer{}
+	hook.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &beacon.Event{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, id := range reject {
+			if event.Container.ID == id {
+				http.Error(w, "rejected", http.StatusBadRequest)
+				return
+			}
+		}
+		hook.lock.Lock()
+		defer hook.lock.Unlock()
+		hook.ids = append(hook.ids, event.Container.ID)
+	}))
+	return hook
+}
+
+func (h *hookServer) IDs() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ids := append([]string{}, h.ids...)
+	sort.Strings(ids)
+	return ids
+}
+
+// replaySetup writes dead letters for the given routes and container IDs to a
+// dead letter file in a temp dir. It returns the config of a webhook backend
+// named `hook` which dead letters to the same file.
+func replaySetup(t *testing.T, hookURL string, letters map[string][]string) (config *Config, dir, path string) {
+	dir, err := ioutil.TempDir("", "beacon-replay-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "dead-letters")
+	sink := beacon.NewDeadLetterFile(path)
+	for route, ids := range letters {
+		for _, id := range ids {
+			err := sink.DeadLetter(&beacon.DeadLetter{
+				Route:    route,
+				Event:    &beacon.Event{Action: beacon.Start, Container: &beacon.Container{ID: id, Service: "www"}},
+				Error:    "test error",
+				Attempts: 1,
+				Time:     time.Now().UTC(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	config, err = loadConfig(t, fmt.Sprintf(`
+docker:
+  label: service
+backends:
+- name: hook
+  webhook:
+    url: %s
+  retry:
+    attempts: 1
+  dead-letter:
+    file: %s
+  spool: %s
+`, hookURL, path, filepath.Join(dir, "spool")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, dir, path
+}
+
+// letterIDs returns the sorted routes and container IDs of the dead letters
+// in a file.
+func letterIDs(t *testing.T, path string) []string {
+	letters, err := beacon.ReadDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(letters))
+	for n, letter := range letters {
+		ids[n] = letter.Route + "/" + letter.Event.Container.ID
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestReplay(t *testing.T) {
+	hook := newHookServer()
+	defer hook.Close()
+	config, dir, path := replaySetup(t, hook.URL, map[string][]string{
+		"hook": {"a", "b"},
+		"gone": {"c"},
+	})
+	defer os.RemoveAll(dir)
+
+	if err := Replay(config, path); err != nil {
+		t.Fatal(err)
+	}
+	if have := hook.IDs(); fmt.Sprint(have) != "[a b]" {
+		t.Errorf("have replayed %v, want [a b]", have)
+	}
+	if have := letterIDs(t, path); fmt.Sprint(have) != "[gone/c]" {
+		t.Errorf("have kept %v, want [gone/c]", have)
+	}
+	if _, err := os.Stat(path + ".replay"); !os.IsNotExist(err) {
+		t.Errorf("replay file was not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "spool")); !os.IsNotExist(err) {
+		t.Errorf("replay used the backend's spool: %v", err)
+	}
+}
+
+func TestReplayPartialFailure(t *testing.T) {
+	hook := newHookServer("b")
+	defer hook.Close()
+	config, dir, path := replaySetup(t, hook.URL, map[string][]string{
+		"hook": {"a", "b"},
+	})
+	defer os.RemoveAll(dir)
+
+	if err := Replay(config, path); err != nil {
+		t.Fatal(err)
+	}
+	if have := hook.IDs(); fmt.Sprint(have) != "[a]" {
+		t.Errorf("have replayed %v, want [a]", have)
+	}
+	// the rejected event is dead lettered again
+	if have := letterIDs(t, path); fmt.Sprint(have) != "[hook/b]" {
+		t.Errorf("have dead letters %v, want [hook/b]", have)
+	}
+}
+
+func TestReplayLeftover(t *testing.T) {
+	hook := newHookServer()
+	defer hook.Close()
+	config, dir, path := replaySetup(t, hook.URL, map[string][]string{
+		"hook": {"a"},
+	})
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path+".replay", []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Replay(config, path); err == nil {
+		t.Fatal("expected error with a leftover replay file")
+	}
+	if have := hook.IDs(); len(have) != 0 {
+		t.Errorf("have replayed %v, want none", have)
+	}
+	if have := letterIDs(t, path); fmt.Sprint(have) != "[hook/a]" {
+		t.Errorf("have dead letters %v, want [hook/a]", have)
+	}
+	if data, err := ioutil.ReadFile(path + ".replay"); err != nil || string(data) != "{}\n" {
+		t.Errorf("leftover replay file was changed: %q %v", data, err)
+	}
+}
+
+func TestReplayBadBackend(t *testing.T) {
+	hook := newHookServer()
+	defer hook.Close()
+	config, dir, path := replaySetup(t, hook.URL, map[string][]string{
+		"hook": {"a"},
+	})
+	defer os.RemoveAll(dir)
+	config.Backends[0].Filter.Expression = "color == ("
+
+	if err := Replay(config, path); err == nil {
+		t.Fatal("expected error with an invalid backend")
+	}
+	if have := letterIDs(t, path); fmt.Sprint(have) != "[hook/a]" {
+		t.Errorf("have dead letters %v, want [hook/a]", have)
+	}
+	if _, err := os.Stat(path + ".replay"); !os.IsNotExist(err) {
+		t.Errorf("dead letter file was moved aside: %v", err)
+	}
+
+	// a later replay is not refused
+	config.Backends[0].Filter.Expression = ""
+	if err := Replay(config, path); err != nil {
+		t.Fatal(err)
+	}
+	if have := hook.IDs(); fmt.Sprint(have) != "[a]" {
+		t.Errorf("have replayed %v, want [a]", have)
+	}
+}
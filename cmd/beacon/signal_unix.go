@@ -12,3 +12,10 @@ func notifyOnStop() <-chan os.Signal {
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	return ch
 }
+
+// notifyOnReload wires up the reload signal for Unix.
+func notifyOnReload() <-chan os.Signal {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	return ch
+}
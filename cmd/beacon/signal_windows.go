@@ -12,3 +12,9 @@ func notifyOnStop() <-chan os.Signal {
 	signal.Notify(ch, os.Interrupt)
 	return ch
 }
+
+// notifyOnReload returns a channel which never receives as Windows has no
+// reload signal.
+func notifyOnReload() <-chan os.Signal {
+	return make(chan os.Signal)
+}
@@ -0,0 +1,343 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultAddress is the address of the local Consul agent.
+const DefaultAddress = "http://127.0.0.1:8500"
+
+// containerMeta is the service meta key which holds the ID of the container an
+// instance was registered for. It marks the instances owned by Beacon.
+const containerMeta = "beacon_container"
+
+// Limits which Consul places on service meta data.
+const (
+	maxMetaPairs       = 64
+	maxMetaKeyLength   = 128
+	maxMetaValueLength = 512
+	reservedMetaPrefix = "consul-"
+)
+
+// Characters which are replaced in service IDs and meta keys.
+var (
+	invalidIDChars   = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	invalidMetaChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+)
+
+// New creates a Consul backend which registers container bindings with the
+// Consul agent at `address`. The ACL `token` is sent with each request if it
+// is not empty.
+//
+// Each binding of a container is registered as an instance of the container's
+// service. Labels are added to the instance as `key=value` tags and as meta
+// data. Labels which Consul does not accept as meta data are left out of it
+// and values which are too long are truncated. If checkInterval is positive
+// then each TCP binding is given a TCP health check which runs at that
+// interval.
+//
+// The backend is a beacon.Syncer. Once the runtime has reported its running
+// containers, instances previously registered by Beacon which do not belong to
+// them are deregistered and missing instances are registered.
+func New(address, token string, checkInterval time.Duration) beacon.Backend {
+	if address == "" {
+		address = DefaultAddress
+	}
+	return &consul{
+		client:        &http.Client{Timeout: 10 * time.Second},
+		address:       strings.TrimSuffix(address, "/"),
+		token:         token,
+		checkInterval: checkInterval,
+		lock:          &sync.Mutex{},
+		registered:    map[string][]string{},
+	}
+}
+
+// consul registers container bindings with a Consul agent.
+type consul struct {
+	client        *http.Client
+	address       string
+	token         string
+	checkInterval time.Duration
+	lock          *sync.Mutex
+	registered    map[string][]string // instance IDs by container ID
+}
+
+// agentService is a service instance registered with the agent.
+type agentService struct {
+	ID      string
+	Service string            `json:",omitempty"`
+	Name    string            `json:",omitempty"`
+	Tags    []string          `json:",omitempty"`
+	Address string            `json:",omitempty"`
+	Port    int               `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Check   *agentCheck       `json:",omitempty"`
+}
+
+// agentCheck is a health check attached to a service instance.
+type agentCheck struct {
+	Name     string
+	TCP      string
+	Interval string
+}
+
+// ProcessEvent registers the bindings of started and updated containers and
+// deregisters the bindings of stopped containers. Instances of a container
+// which no longer match one of its bindings are deregistered. OOM events are
+// ignored. Client errors, other than throttling, are marked permanent.
+func (c *consul) ProcessEvent(event *beacon.Event) error {
+	return markPermanent(c.process(event))
+}
+
+// Sync deregisters instances registered by Beacon which do not belong to one of
+// the containers, such as those of containers which stopped while Beacon was
+// down, and registers the containers' missing instances.
+func (c *consul) Sync(containers []*beacon.Container) error {
+	return markPermanent(c.sync(containers))
+}
+
+// markPermanent marks client errors, other than throttling, permanent.
+func markPermanent(err error) error {
+	if statusErr, ok := errors.Cause(err).(*statusError); ok && statusErr.permanent() {
+		return beacon.Permanent(err)
+	}
+	return err
+}
+
+func (c *consul) process(event *beacon.Event) error {
+	if event.Action == beacon.OOM {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	id := event.Container.ID
+	registered := map[string]struct{}{}
+	ids := []string{}
+	if event.Action != beacon.Stop {
+		for _, service := range c.services(event.Container) {
+			if err := c.do("PUT", "/v1/agent/service/register", service, nil); err != nil {
+				return errors.Wrapf(err, "failed to register service %s", service.ID)
+			}
+			registered[service.ID] = struct{}{}
+			ids = append(ids, service.ID)
+		}
+	}
+
+	// instances registered before Beacon restarted are not known until the
+	// backend is synced, which deregisters those left over
+	for _, serviceID := range c.registered[id] {
+		if _, ok := registered[serviceID]; !ok {
+			if err := c.deregister(serviceID); err != nil {
+				return err
+			}
+		}
+	}
+	if len(ids) > 0 {
+		c.registered[id] = ids
+	} else {
+		delete(c.registered, id)
+	}
+	return nil
+}
+
+// services returns a service instance for each binding of a container.
+func (c *consul) services(container *beacon.Container) []*agentService {
+	tags := make([]string, 0, len(container.Labels))
+	for key, value := range container.Labels {
+		tags = append(tags, key+"="+value)
+	}
+	meta := serviceMeta(container)
+
+	services := make([]*agentService, len(container.Bindings))
+	for n, binding := range container.Bindings {
+		id := fmt.Sprintf("beacon-%s-%s-%d-%s", container.ID, binding.HostIP, binding.HostPort, binding.Protocol)
+		service := &agentService{
+			ID:      invalidIDChars.ReplaceAllString(id, "-"),
+			Name:    container.Service,
+			Tags:    tags,
+			Address: binding.HostIP,
+			Port:    binding.HostPort,
+			Meta:    meta,
+		}
+		if c.checkInterval > 0 && binding.Protocol == beacon.TCP {
+			service.Check = &agentCheck{
+				Name:     "TCP " + net.JoinHostPort(binding.HostIP, fmt.Sprint(binding.HostPort)),
+				TCP:      net.JoinHostPort(binding.HostIP, fmt.Sprint(binding.HostPort)),
+				Interval: c.checkInterval.String(),
+			}
+		}
+		services[n] = service
+	}
+	return services
+}
+
+// serviceMeta returns the meta data of a container's instances. Labels are
+// added in order of their keys until Consul's limit on the number of pairs is
+// reached. Labels with keys which Consul rejects are skipped and values which
+// are too long are truncated.
+func serviceMeta(container *beacon.Container) map[string]string {
+	keys := make([]string, 0, len(container.Labels))
+	for key := range container.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	meta := map[string]string{containerMeta: container.ID}
+	for _, label := range keys {
+		key := invalidMetaChars.ReplaceAllString(label, "_")
+		value := container.Labels[label]
+		switch {
+		case len(meta) >= maxMetaPairs:
+			Logger.Printf("dropping consul meta %s of container %s: too many labels", key, container.ID)
+			continue
+		case len(key) > maxMetaKeyLength:
+			Logger.Printf("dropping consul meta %s of container %s: key is too long", key, container.ID)
+			continue
+		case strings.HasPrefix(key, reservedMetaPrefix) || key == containerMeta:
+			Logger.Printf("dropping consul meta %s of container %s: key is reserved", key, container.ID)
+			continue
+		case len(value) > maxMetaValueLength:
+			Logger.Printf("truncating consul meta %s of container %s to %d bytes", key, container.ID, maxMetaValueLength)
+			n := maxMetaValueLength
+			for n > 0 && !utf8.RuneStart(value[n]) {
+				n--
+			}
+			value = value[:n]
+		}
+		meta[key] = value
+	}
+	return meta
+}
+
+// list returns the service instances registered by Beacon.
+func (c *consul) list() ([]*agentService, error) {
+	all := map[string]*agentService{}
+	if err := c.do("GET", "/v1/agent/services", nil, &all); err != nil {
+		return nil, errors.Wrap(err, "failed to list services")
+	}
+	services := make([]*agentService, 0, len(all))
+	for _, service := range all {
+		if _, ok := service.Meta[containerMeta]; ok {
+			services = append(services, service)
+		}
+	}
+	return services, nil
+}
+
+// deregister removes a service instance from the agent. Instances which are
+// already gone are ignored.
+func (c *consul) deregister(id string) error {
+	err := c.do("PUT", "/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil)
+	if statusErr, ok := errors.Cause(err).(*statusError); ok && statusErr.status == http.StatusNotFound {
+		return nil
+	}
+	return errors.Wrapf(err, "failed to deregister service %s", id)
+}
+
+func (c *consul) sync(containers []*beacon.Container) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expected := map[string]*agentService{}
+	registered := map[string][]string{}
+	for _, container := range containers {
+		for _, service := range c.services(container) {
+			expected[service.ID] = service
+			registered[container.ID] = append(registered[container.ID], service.ID)
+		}
+	}
+
+	services, err := c.list()
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		if _, ok := expected[service.ID]; ok {
+			delete(expected, service.ID)
+			continue
+		}
+		Logger.Printf("deregistering stale consul service %s", service.ID)
+		if err := c.deregister(service.ID); err != nil {
+			return err
+		}
+	}
+	for _, service := range expected {
+		Logger.Printf("registering missing consul service %s", service.ID)
+		if err := c.do("PUT", "/v1/agent/service/register", service, nil); err != nil {
+			return errors.Wrapf(err, "failed to register service %s", service.ID)
+		}
+	}
+	c.registered = registered
+	return nil
+}
+
+// statusError is returned for unsuccessful responses from the agent.
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %s", http.StatusText(e.status), e.message)
+}
+
+// permanent returns true if the request will fail again if retried.
+func (e *statusError) permanent() bool {
+	return e.status >= 400 && e.status < 500 && e.status != http.StatusTooManyRequests
+}
+
+// do sends a request to the agent. The `in` value is sent as the JSON request
+// body and the response body is decoded into `out` if they are not nil.
+func (c *consul) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize request")
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.address+path, body)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(res.Body)
+		return &statusError{res.StatusCode, strings.TrimSpace(string(message))}
+	}
+	if out != nil {
+		return errors.Wrap(json.NewDecoder(res.Body).Decode(out), "failed to decode response")
+	}
+	return nil
+}
+
+// Close is a noop.
+func (c *consul) Close() error {
+	return nil
+}
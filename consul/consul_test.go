@@ -0,0 +1,323 @@
+package consul_test
+
+import (
+	consul "."
+	"encoding/json"
+	"fmt"
+	"github.com/BlueDragonX/beacon/beacon"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Service is a service instance as stored by FakeAgent.
+type Service struct {
+	ID      string
+	Service string
+	Tags    []string
+	Address string
+	Port    int
+	Meta    map[string]string
+	Check   *Check `json:",omitempty"`
+}
+
+// Check is a service check as stored by FakeAgent.
+type Check struct {
+	Name     string
+	TCP      string
+	Interval string
+}
+
+// FakeAgent serves the service endpoints of the Consul agent API.
+type FakeAgent struct {
+	*httptest.Server
+	lock     sync.Mutex
+	token    string
+	status   int
+	lists    int
+	services map[string]*Service
+}
+
+func NewFakeAgent(token string) *FakeAgent {
+	agent := &FakeAgent{
+		token:    token,
+		services: map[string]*Service{},
+	}
+	agent.Server = httptest.NewServer(http.HandlerFunc(agent.serve))
+	return agent
+}
+
+// SetStatus makes the agent fail requests with the status. A zero status
+// restores normal operation.
+func (a *FakeAgent) SetStatus(status int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.status = status
+}
+
+// AddService registers a service instance.
+func (a *FakeAgent) AddService(service *Service) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.services[service.ID] = service
+}
+
+// Lists returns the number of times the services have been listed.
+func (a *FakeAgent) Lists() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.lists
+}
+
+// Services returns the registered service instances sorted by ID.
+func (a *FakeAgent) Services() []*Service {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	services := make([]*Service, 0, len(a.services))
+	for _, service := range a.services {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ID < services[j].ID
+	})
+	return services
+}
+
+func (a *FakeAgent) serve(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.status != 0 {
+		http.Error(w, "test error", a.status)
+		return
+	} else if r.Header.Get("X-Consul-Token") != a.token {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/agent/services":
+		a.lists++
+		json.NewEncoder(w).Encode(a.services)
+	case r.Method == "PUT" && r.URL.Path == "/v1/agent/service/register":
+		registration := struct {
+			Service
+			Name string
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		service := registration.Service
+		service.Service = registration.Name
+		a.services[service.ID] = &service
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+		if _, ok := a.services[id]; !ok {
+			http.Error(w, "unknown service", http.StatusNotFound)
+			return
+		}
+		delete(a.services, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestConsul(t *testing.T) {
+	t.Parallel()
+	agent := NewFakeAgent("token")
+	defer agent.Close()
+	backend := consul.New(agent.URL, "token", 10*time.Second)
+	defer backend.Close()
+
+	container := &beacon.Container{
+		ID:      "512b64138152",
+		Service: "dns",
+		Labels:  map[string]string{"com.example.env": "prod"},
+		Bindings: []*beacon.Binding{
+			{HostIP: "10.1.1.100", HostPort: 53, ContainerPort: 53, Protocol: beacon.TCP},
+			{HostIP: "10.1.1.100", HostPort: 53, ContainerPort: 53, Protocol: beacon.UDP},
+		},
+	}
+	if err := backend.ProcessEvent(&beacon.Event{Action: beacon.Start, Container: container}); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := map[string]string{"com_example_env": "prod", "beacon_container": "512b64138152"}
+	want := []*Service{
+		{
+			ID:      "beacon-512b64138152-10.1.1.100-53-tcp",
+			Service: "dns",
+			Tags:    []string{"com.example.env=prod"},
+			Address: "10.1.1.100",
+			Port:    53,
+			Meta:    meta,
+			Check:   &Check{Name: "TCP 10.1.1.100:53", TCP: "10.1.1.100:53", Interval: "10s"},
+		},
+		{
+			ID:      "beacon-512b64138152-10.1.1.100-53-udp",
+			Service: "dns",
+			Tags:    []string{"com.example.env=prod"},
+			Address: "10.1.1.100",
+			Port:    53,
+			Meta:    meta,
+		},
+	}
+	if have := agent.Services(); !reflect.DeepEqual(have, want) {
+		t.Errorf("have services %+v, want %+v", have, want)
+	}
+
+	// the udp binding is removed
+	container.Bindings = container.Bindings[:1]
+	if err := backend.ProcessEvent(&beacon.Event{Action: beacon.Update, Container: container}); err != nil {
+		t.Fatal(err)
+	}
+	if have := agent.Services(); !reflect.DeepEqual(have, want[:1]) {
+		t.Errorf("have services %+v, want %+v", have, want[:1])
+	}
+
+	stop := &beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: "512b64138152"}}
+	if err := backend.ProcessEvent(stop); err != nil {
+		t.Fatal(err)
+	}
+	if have := agent.Services(); len(have) != 0 {
+		t.Errorf("have %d services after stop, want 0", len(have))
+	}
+	if lists := agent.Lists(); lists != 0 {
+		t.Errorf("services were listed %d times, want 0", lists)
+	}
+}
+
+func TestConsulMetaLimits(t *testing.T) {
+	t.Parallel()
+	agent := NewFakeAgent("")
+	defer agent.Close()
+	backend := consul.New(agent.URL, "", 0)
+	defer backend.Close()
+
+	labels := map[string]string{
+		"consul-version":         "1",
+		"description":            strings.Repeat("a", 511) + "é",
+		strings.Repeat("k", 129): "1",
+	}
+	for n := 0; n < 70; n++ {
+		labels[fmt.Sprintf("label%02d", n)] = "1"
+	}
+	container := &beacon.Container{
+		ID:      "1",
+		Service: "www",
+		Labels:  labels,
+		Bindings: []*beacon.Binding{
+			{HostIP: "10.1.1.100", HostPort: 80, ContainerPort: 80, Protocol: beacon.TCP},
+		},
+	}
+	if err := backend.ProcessEvent(&beacon.Event{Action: beacon.Start, Container: container}); err != nil {
+		t.Fatal(err)
+	}
+
+	services := agent.Services()
+	if len(services) != 1 {
+		t.Fatalf("have %d services, want 1", len(services))
+	}
+	meta := services[0].Meta
+	if len(meta) != 64 {
+		t.Errorf("have %d meta keys, want 64", len(meta))
+	}
+	if _, ok := meta["consul-version"]; ok {
+		t.Error("reserved meta key was registered")
+	}
+	if value := meta["description"]; value != strings.Repeat("a", 511) {
+		t.Errorf("have description of %d bytes, want 511", len(value))
+	}
+	if _, ok := meta["label61"]; !ok {
+		t.Error("meta label61 is missing")
+	} else if _, ok := meta["label62"]; ok {
+		t.Error("meta label62 is beyond the limit")
+	}
+	if len(services[0].Tags) != len(labels) {
+		t.Errorf("have %d tags, want %d", len(services[0].Tags), len(labels))
+	}
+}
+
+func TestConsulSync(t *testing.T) {
+	t.Parallel()
+	agent := NewFakeAgent("")
+	defer agent.Close()
+	stale := &Service{ID: "beacon-old-10.1.1.100-80-tcp", Service: "www", Meta: map[string]string{"beacon_container": "old"}}
+	kept := &Service{ID: "beacon-kept-10.1.1.100-8081-tcp", Service: "www", Meta: map[string]string{"beacon_container": "kept"}}
+	other := &Service{ID: "redis", Service: "redis"}
+	agent.AddService(stale)
+	agent.AddService(kept)
+	agent.AddService(other)
+
+	backend := consul.New(agent.URL, "", 0)
+	defer backend.Close()
+	containers := []*beacon.Container{
+		{
+			ID:      "kept",
+			Service: "www",
+			Bindings: []*beacon.Binding{
+				{HostIP: "10.1.1.100", HostPort: 8081, ContainerPort: 80, Protocol: beacon.TCP},
+			},
+		},
+		{
+			ID:      "new",
+			Service: "www",
+			Bindings: []*beacon.Binding{
+				{HostIP: "10.1.1.100", HostPort: 8080, ContainerPort: 80, Protocol: beacon.TCP},
+			},
+		},
+	}
+	if err := backend.(beacon.Syncer).Sync(containers); err != nil {
+		t.Fatal(err)
+	}
+
+	have := []string{}
+	for _, service := range agent.Services() {
+		have = append(have, service.ID)
+	}
+	want := []string{"beacon-kept-10.1.1.100-8081-tcp", "beacon-new-10.1.1.100-8080-tcp", "redis"}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have services %v, want %v", have, want)
+	}
+}
+
+func TestConsulErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusForbidden, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+	}
+	agent := NewFakeAgent("")
+	defer agent.Close()
+	backend := consul.New(agent.URL, "", 0)
+	defer backend.Close()
+
+	for _, test := range tests {
+		agent.SetStatus(test.status)
+		err := backend.ProcessEvent(&beacon.Event{
+			Action: beacon.Start,
+			Container: &beacon.Container{
+				ID:      "1",
+				Service: "www",
+				Bindings: []*beacon.Binding{
+					{HostIP: "10.1.1.100", HostPort: 80, ContainerPort: 80, Protocol: beacon.TCP},
+				},
+			},
+		})
+		if err == nil {
+			t.Errorf("status %d: expected error", test.status)
+		} else if beacon.IsPermanent(err) != test.permanent {
+			t.Errorf("status %d: have permanent %t, want %t", test.status, beacon.IsPermanent(err), test.permanent)
+		}
+	}
+}
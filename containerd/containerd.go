@@ -0,0 +1,291 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/BlueDragonX/beacon/metrics"
+	containerdclient "github.com/containerd/containerd"
+	apievents "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/events"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/typeurl/v2"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"sync"
+	"time"
+)
+
+var (
+	errContainerIgnored = errors.New("container ignored")
+)
+
+var (
+	// ReconnectBackoff is how long the runtime waits before subscribing to
+	// containerd events again after losing its subscription. The wait
+	// doubles after each failed attempt.
+	ReconnectBackoff = time.Second
+
+	// ReconnectMaxBackoff is the longest the runtime waits between attempts
+	// to subscribe to containerd events.
+	ReconnectMaxBackoff = 30 * time.Second
+)
+
+// New creates a containerd runtime which listens for task events in
+// `namespace` on the containerd socket at `address`.
+//
+// The serviceLabel is used to look up the service name from the labels on the
+// container. Containers without this label are ignored. Containerd does not
+// publish ports so containers have no bindings.
+//
+// If the event subscription is lost the runtime subscribes again with backoff
+// and lists the running tasks. Start events are sent for the running
+// containers and Stop events for those which stopped in the meantime.
+func New(address, namespace, serviceLabel string) (beacon.Runtime, error) {
+	client, err := containerdclient.New(address)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create containerd client")
+	}
+	return newContainerd(client, address, namespace, serviceLabel)
+}
+
+// NewWithConn works like New but talks to containerd over an existing gRPC
+// connection. The connection is closed along with the runtime.
+func NewWithConn(conn *grpc.ClientConn, namespace, serviceLabel string) (beacon.Runtime, error) {
+	client, err := containerdclient.NewWithConn(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create containerd client")
+	}
+	return newContainerd(client, conn.Target(), namespace, serviceLabel)
+}
+
+func newContainerd(client *containerdclient.Client, address, namespace, serviceLabel string) (beacon.Runtime, error) {
+	if namespace == "" {
+		client.Close()
+		return nil, errors.Errorf("invalid namespace %s", namespace)
+	}
+	ctx, cancel := context.WithCancel(namespaces.WithNamespace(context.Background(), namespace))
+	return &containerd{
+		address:      address,
+		namespace:    namespace,
+		client:       client,
+		serviceLabel: serviceLabel,
+		backoff:      ReconnectBackoff,
+		maxBackoff:   ReconnectMaxBackoff,
+		wg:           &sync.WaitGroup{},
+		ctx:          ctx,
+		cancel:       cancel,
+	}, nil
+}
+
+// containerd implements a Beacon runtime for the containerd daemon.
+type containerd struct {
+	address      string
+	namespace    string
+	client       *containerdclient.Client
+	serviceLabel string
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	wg           *sync.WaitGroup
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+// EmitEvents sends containerd task events to Beacon.
+func (c *containerd) EmitEvents() (<-chan *beacon.Event, error) {
+	beaconEvents := make(chan *beacon.Event, 1)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer close(beaconEvents)
+
+		running := map[string]struct{}{}
+
+		send := func(event *beacon.Event) bool {
+			select {
+			case beaconEvents <- event:
+			case <-c.ctx.Done():
+				return false
+			}
+			return true
+		}
+
+		sendStart := func(cntr *beacon.Container) bool {
+			running[cntr.ID] = struct{}{}
+			return send(&beacon.Event{
+				Action:    beacon.Start,
+				Container: cntr,
+			})
+		}
+
+		sendStop := func(id string) bool {
+			if _, ok := running[id]; !ok {
+				return true
+			}
+			delete(running, id)
+			return send(&beacon.Event{
+				Action: beacon.Stop,
+				Container: &beacon.Container{
+					ID: id,
+				},
+			})
+		}
+
+		// resync sends a start for each running container and a stop for each
+		// container which is no longer running.
+		resync := func() (bool, error) {
+			containers, err := c.listContainers()
+			if err != nil {
+				return true, err
+			}
+			listed := make(map[string]struct{}, len(containers))
+			for _, container := range containers {
+				listed[container.ID] = struct{}{}
+				if !sendStart(container) {
+					return false, nil
+				}
+			}
+			for id := range running {
+				if _, ok := listed[id]; !ok {
+					if !sendStop(id) {
+						return false, nil
+					}
+				}
+			}
+			return true, nil
+		}
+
+		handle := func(envelope *events.Envelope) bool {
+			event, err := typeurl.UnmarshalAny(envelope.Event)
+			if err != nil {
+				Logger.Printf("failed to decode containerd event %s: %s", envelope.Topic, err)
+				return true
+			}
+			switch event := event.(type) {
+			case *apievents.TaskStart:
+				if container, err := c.inspectContainer(event.ContainerID); err == nil {
+					return sendStart(container)
+				} else if err != errContainerIgnored {
+					Logger.Printf("failed to inspect container %s: %s", event.ContainerID, err)
+				}
+			case *apievents.TaskExit:
+				// exec'd processes exit with their own ID
+				if event.ID == event.ContainerID {
+					return sendStop(event.ContainerID)
+				}
+			}
+			return true
+		}
+
+		// consume handles events until the subscription fails or the runtime
+		// is closed.
+		consume := func(envelopes <-chan *events.Envelope, errs <-chan error) (bool, error) {
+			for {
+				select {
+				case envelope := <-envelopes:
+					if !handle(envelope) {
+						return false, nil
+					}
+				case err := <-errs:
+					if err == nil {
+						err = errors.New("subscription closed")
+					}
+					return true, err
+				case <-c.ctx.Done():
+					return false, nil
+				}
+			}
+		}
+
+		synced := false
+		delay := c.backoff
+		for {
+			// subscribe before listing tasks so that no events are missed
+			ctx, cancel := context.WithCancel(c.ctx)
+			envelopes, errs := c.client.EventService().Subscribe(ctx,
+				fmt.Sprintf(`namespace==%q,topic=="/tasks/start"`, c.namespace),
+				fmt.Sprintf(`namespace==%q,topic=="/tasks/exit"`, c.namespace),
+			)
+			ok, err := resync()
+			if ok && err == nil {
+				if !synced {
+					synced = true
+					ok = send(&beacon.Event{Action: beacon.Synced})
+				}
+				if ok {
+					delay = c.backoff
+					ok, err = consume(envelopes, errs)
+				}
+			}
+			cancel()
+			if !ok {
+				return
+			}
+
+			Logger.Printf("lost connection to containerd on %s, retrying in %s: %s", c.address, delay, err)
+			select {
+			case <-time.After(delay):
+			case <-c.ctx.Done():
+				return
+			}
+			if delay *= 2; delay > c.maxBackoff {
+				delay = c.maxBackoff
+			}
+		}
+	}()
+
+	Logger.Printf("listening for containerd events in namespace %s on %s", c.namespace, c.address)
+	return beaconEvents, nil
+}
+
+func (c *containerd) listContainers() ([]*beacon.Container, error) {
+	resp, err := c.client.TaskService().List(c.ctx, &tasks.ListTasksRequest{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list tasks")
+	}
+
+	containers := make([]*beacon.Container, 0, len(resp.Tasks))
+	for _, task := range resp.Tasks {
+		if task.Status != tasktypes.Status_RUNNING {
+			continue
+		}
+		container, err := c.inspectContainer(task.ID)
+		if err == errContainerIgnored {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to list containers")
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+func (c *containerd) inspectContainer(id string) (*beacon.Container, error) {
+	container, err := c.client.ContainerService().Get(c.ctx, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect container %s", id)
+	}
+
+	service, ok := container.Labels[c.serviceLabel]
+	if !ok {
+		metrics.Ignored.Inc()
+		return nil, errContainerIgnored
+	}
+
+	return &beacon.Container{
+		ID:       container.ID,
+		Service:  service,
+		Labels:   container.Labels,
+		Bindings: []*beacon.Binding{},
+	}, nil
+}
+
+// Close the connection to containerd and stop emitting events.
+func (c *containerd) Close() error {
+	c.cancel()
+	c.wg.Wait()
+	return c.client.Close()
+}
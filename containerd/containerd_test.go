@@ -0,0 +1,287 @@
+package containerd_test
+
+import (
+	containerd "."
+	"context"
+	"github.com/BlueDragonX/beacon/beacon"
+	apievents "github.com/containerd/containerd/api/events"
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/protobuf"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const namespace = "beacon"
+
+func init() {
+	containerd.ReconnectBackoff = 10 * time.Millisecond
+}
+
+// FakeContainerd serves the events, containers and tasks services used by the
+// runtime. Event streams stay open until Disconnect is called.
+type FakeContainerd struct {
+	Subscribes chan struct{}
+	server     *grpc.Server
+	listener   *bufconn.Listener
+	events     chan *eventsapi.Envelope
+	lock       sync.Mutex
+	containers map[string]*containersapi.Container
+	running    map[string]bool
+	disconnect chan struct{}
+}
+
+func NewFakeContainerd() *FakeContainerd {
+	c := &FakeContainerd{
+		Subscribes: make(chan struct{}, 16),
+		server:     grpc.NewServer(),
+		listener:   bufconn.Listen(1024 * 1024),
+		events:     make(chan *eventsapi.Envelope, 16),
+		containers: map[string]*containersapi.Container{},
+		running:    map[string]bool{},
+		disconnect: make(chan struct{}),
+	}
+	eventsapi.RegisterEventsServer(c.server, &eventsServer{c: c})
+	containersapi.RegisterContainersServer(c.server, &containersServer{c: c})
+	tasks.RegisterTasksServer(c.server, &tasksServer{c: c})
+	go c.server.Serve(c.listener)
+	return c
+}
+
+// Dial connects to the fake.
+func (c *FakeContainerd) Dial(t *testing.T) *grpc.ClientConn {
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return c.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// SetContainer creates or replaces a container with the given labels.
+func (c *FakeContainerd) SetContainer(id string, running bool, labels map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.containers[id] = &containersapi.Container{ID: id, Labels: labels}
+	c.running[id] = running
+}
+
+// SendEvent sends an event to the subscriber.
+func (c *FakeContainerd) SendEvent(t *testing.T, topic string, event interface{}) {
+	any, err := protobuf.MarshalAnyToProto(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.events <- &eventsapi.Envelope{
+		Timestamp: timestamppb.Now(),
+		Namespace: namespace,
+		Topic:     topic,
+		Event:     any,
+	}
+}
+
+// Disconnect closes the open event streams.
+func (c *FakeContainerd) Disconnect() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	close(c.disconnect)
+	c.disconnect = make(chan struct{})
+}
+
+// WaitForSubscribe waits for the runtime to subscribe to events.
+func (c *FakeContainerd) WaitForSubscribe(t *testing.T) {
+	select {
+	case <-c.Subscribes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for subscription")
+	}
+}
+
+// Close stops the server.
+func (c *FakeContainerd) Close() {
+	c.server.Stop()
+}
+
+type eventsServer struct {
+	eventsapi.UnimplementedEventsServer
+	c *FakeContainerd
+}
+
+func (s *eventsServer) Subscribe(req *eventsapi.SubscribeRequest, stream eventsapi.Events_SubscribeServer) error {
+	c := s.c
+	c.lock.Lock()
+	disconnect := c.disconnect
+	c.lock.Unlock()
+	c.Subscribes <- struct{}{}
+	for {
+		select {
+		case envelope := <-c.events:
+			if err := stream.Send(envelope); err != nil {
+				return err
+			}
+		case <-disconnect:
+			return status.Error(codes.Unavailable, "disconnected")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+type containersServer struct {
+	containersapi.UnimplementedContainersServer
+	c *FakeContainerd
+}
+
+func (s *containersServer) Get(ctx context.Context, req *containersapi.GetContainerRequest) (*containersapi.GetContainerResponse, error) {
+	c := s.c
+	if ns, _ := namespaces.Namespace(ctx); ns != namespace {
+		return nil, status.Errorf(codes.FailedPrecondition, "wrong namespace %s", ns)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	container, ok := c.containers[req.ID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "container %s not found", req.ID)
+	}
+	return &containersapi.GetContainerResponse{Container: container}, nil
+}
+
+type tasksServer struct {
+	tasks.UnimplementedTasksServer
+	c *FakeContainerd
+}
+
+func (s *tasksServer) List(ctx context.Context, req *tasks.ListTasksRequest) (*tasks.ListTasksResponse, error) {
+	c := s.c
+	if ns, _ := namespaces.Namespace(ctx); ns != namespace {
+		return nil, status.Errorf(codes.FailedPrecondition, "wrong namespace %s", ns)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	resp := &tasks.ListTasksResponse{}
+	for id, running := range c.running {
+		process := &tasktypes.Process{ID: id, Status: tasktypes.Status_STOPPED}
+		if running {
+			process.Status = tasktypes.Status_RUNNING
+		}
+		resp.Tasks = append(resp.Tasks, process)
+	}
+	return resp, nil
+}
+
+// WaitForEvents waits for `n` events.
+func WaitForEvents(ch <-chan *beacon.Event, n int, timeout time.Duration) ([]*beacon.Event, error) {
+	events := make([]*beacon.Event, 0, n)
+	timer := time.After(timeout)
+	for len(events) < n {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return events, errors.New("channel closed")
+			}
+			events = append(events, event)
+		case <-timer:
+			return events, errors.New("timed out")
+		}
+	}
+	return events, nil
+}
+
+type wantEvent struct {
+	action beacon.Action
+	id     string
+}
+
+func checkEvents(t *testing.T, ch <-chan *beacon.Event, wantEvents []wantEvent) {
+	haveEvents, err := WaitForEvents(ch, len(wantEvents), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range wantEvents {
+		have := haveEvents[n]
+		id := ""
+		if have.Container != nil {
+			id = have.Container.ID
+		}
+		if have.Action != want.action || id != want.id {
+			t.Errorf("have event %s %s, want %s %s", have.Action, id, want.action, want.id)
+		}
+	}
+}
+
+func startRuntime(t *testing.T, daemon *FakeContainerd) (beacon.Runtime, <-chan *beacon.Event) {
+	runtime, err := containerd.NewWithConn(daemon.Dial(t), namespace, "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		runtime.Close()
+		t.Fatal(err)
+	}
+	return runtime, ch
+}
+
+func TestTaskEvents(t *testing.T) {
+	daemon := NewFakeContainerd()
+	defer daemon.Close()
+	daemon.SetContainer("a", true, map[string]string{"service": "www"})
+	daemon.SetContainer("b", true, map[string]string{"env": "prod"})
+	daemon.SetContainer("c", false, map[string]string{"service": "db"})
+
+	runtime, ch := startRuntime(t, daemon)
+	defer runtime.Close()
+	checkEvents(t, ch, []wantEvent{
+		{beacon.Start, "a"},
+		{beacon.Synced, ""},
+	})
+
+	daemon.SendEvent(t, "/tasks/start", &apievents.TaskStart{ContainerID: "c"})
+	daemon.SendEvent(t, "/tasks/start", &apievents.TaskStart{ContainerID: "b"})
+	daemon.SendEvent(t, "/tasks/exit", &apievents.TaskExit{ContainerID: "c", ID: "exec-1"})
+	daemon.SendEvent(t, "/tasks/exit", &apievents.TaskExit{ContainerID: "a", ID: "a"})
+	checkEvents(t, ch, []wantEvent{
+		{beacon.Start, "c"},
+		{beacon.Stop, "a"},
+	})
+}
+
+func TestResubscribe(t *testing.T) {
+	daemon := NewFakeContainerd()
+	defer daemon.Close()
+	daemon.SetContainer("a", true, map[string]string{"service": "www"})
+
+	runtime, ch := startRuntime(t, daemon)
+	defer runtime.Close()
+	daemon.WaitForSubscribe(t)
+	checkEvents(t, ch, []wantEvent{
+		{beacon.Start, "a"},
+		{beacon.Synced, ""},
+	})
+
+	// a stops and b starts while the subscription is down
+	daemon.SetContainer("a", false, map[string]string{"service": "www"})
+	daemon.SetContainer("b", true, map[string]string{"service": "www"})
+	daemon.Disconnect()
+	daemon.WaitForSubscribe(t)
+	checkEvents(t, ch, []wantEvent{
+		{beacon.Start, "b"},
+		{beacon.Stop, "a"},
+	})
+}
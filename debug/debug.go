@@ -47,6 +47,27 @@ func (d *debug) ProcessEvent(event *beacon.Event) error {
 		}
 		fmt.Fprintf(buf, "%s:%d->%d/%s", binding.HostIP, binding.HostPort, binding.ContainerPort, binding.Protocol)
 	}
+
+	for n, port := range event.Container.Ports {
+		if n == 0 {
+			fmt.Fprint(buf, " exposed=")
+		} else {
+			fmt.Fprint(buf, ",")
+		}
+		fmt.Fprintf(buf, "%d/%s", port.ContainerPort, port.Protocol)
+	}
+
+	for n, network := range event.Container.Networks {
+		if n == 0 {
+			fmt.Fprint(buf, " networks=")
+		} else {
+			fmt.Fprint(buf, ",")
+		}
+		fmt.Fprintf(buf, "%s:%s", network.Name, network.IP)
+		if network.IPv6 != "" {
+			fmt.Fprintf(buf, ":[%s]", network.IPv6)
+		}
+	}
 	fmt.Fprint(buf, "\n")
 
 	d.pr.Print(buf.String())
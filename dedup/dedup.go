@@ -0,0 +1,54 @@
+// Package dedup derives the deduplication IDs of messages sent to FIFO SNS
+// topics and SQS queues.
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Interval is how long a FIFO topic or queue remembers deduplication IDs.
+const Interval = 5 * time.Minute
+
+// New creates an empty record of sent deduplication IDs.
+func New() *IDs {
+	return &IDs{sent: map[string]sent{}}
+}
+
+// IDs records the deduplication ID of the last message sent for each
+// container. It is not safe for concurrent use.
+type IDs struct {
+	sent map[string]sent // last message sent by container ID
+}
+
+// sent records the deduplication ID of a sent message.
+type sent struct {
+	id string
+	at time.Time
+}
+
+// ID returns the deduplication ID of a container's message. It hashes the
+// message body along with the ID of the container's previous message so that
+// identical events which follow each other, such as those sent when a
+// container restarts, are not discarded as duplicates.
+func (d *IDs) ID(container string, body []byte) string {
+	hash := sha256.New()
+	if last, ok := d.sent[container]; ok && time.Since(last.at) < Interval {
+		hash.Write([]byte(last.id))
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Sent records the deduplication ID of a container's message and forgets those
+// which the topic or queue no longer remembers.
+func (d *IDs) Sent(container, id string) {
+	now := time.Now()
+	for sentContainer, last := range d.sent {
+		if now.Sub(last.at) >= Interval {
+			delete(d.sent, sentContainer)
+		}
+	}
+	d.sent[container] = sent{id: id, at: now}
+}
@@ -0,0 +1,34 @@
+package dedup_test
+
+import (
+	dedup "."
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestIDs(t *testing.T) {
+	body := []byte(`{"action":"start"}`)
+	ids := dedup.New()
+
+	first := ids.ID("a", body)
+	sum := sha256.Sum256(body)
+	if first != hex.EncodeToString(sum[:]) {
+		t.Errorf("have first id %q, want hash of the body", first)
+	}
+	if again := ids.ID("a", body); again != first {
+		t.Errorf("have id %q before sending, want %q", again, first)
+	}
+
+	// a repeated body has a new id once the first is sent
+	ids.Sent("a", first)
+	second := ids.ID("a", body)
+	if second == first {
+		t.Error("repeated body has the same id")
+	}
+
+	// other containers are not affected
+	if other := ids.ID("b", body); other != first {
+		t.Errorf("have id %q for another container, want %q", other, first)
+	}
+}
@@ -2,29 +2,94 @@ package docker
 
 import (
 	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/BlueDragonX/beacon/metrics"
 	dockerclient "github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 var (
-	errContainerIgnored = errors.New("container ignored")
+	errContainerIgnored  = errors.New("container ignored")
+	errContainerNotReady = errors.New("container not ready")
 )
 
+// eventBuffer is the number of Docker events buffered for the runtime. The
+// Docker client drops events when the buffer is full and a stopping container
+// sends several events at once.
+const eventBuffer = 64
+
+// stopSignals are the kill signals which are expected to stop a container.
+// Other signals, like SIGHUP, are commonly used to reload a process.
+var stopSignals = map[string]struct{}{
+	strconv.Itoa(int(syscall.SIGINT)):  {},
+	strconv.Itoa(int(syscall.SIGQUIT)): {},
+	strconv.Itoa(int(syscall.SIGKILL)): {},
+	strconv.Itoa(int(syscall.SIGTERM)): {},
+}
+
+var (
+	// ReconnectBackoff is how long the runtime waits before reconnecting to
+	// Docker after losing its connection. The wait doubles after each failed
+	// attempt.
+	ReconnectBackoff = time.Second
+
+	// ReconnectMaxBackoff is the longest the runtime waits between attempts
+	// to reconnect to Docker.
+	ReconnectMaxBackoff = 30 * time.Second
+)
+
+// Options are the optional settings of a Docker runtime.
+type Options struct {
+	// HostIPv6 replaces the IPv6 wildcard address (::) in bindings. Bindings
+	// on :: are dropped if it is empty.
+	HostIPv6 string
+
+	// StopOnClose queues a Stop event for each running container when the
+	// runtime is closed.
+	StopOnClose bool
+
+	// WaitForHealthy holds back containers with a health check until Docker
+	// reports them healthy. A Stop event is sent when such a container
+	// becomes unhealthy and a Start event when it recovers. Containers
+	// without a health check are started as soon as they run.
+	WaitForHealthy bool
+
+	// ExcludeUnnamedPorts drops ports which are not named by a port label.
+	ExcludeUnnamedPorts bool
+}
+
 // New creates a Docker runtime from the provided configuration. The runtime
 // listens for container events on the Docker `endpoint`.
 //
 // The hostIP is reported to Beacon as the IP address used to connect to
 // discovered containers when they listen on all host addresses (0.0.0.0).
+// Likewise options.HostIPv6 replaces the IPv6 wildcard address (::). Use
+// ResolveHostIP to detect either address.
 //
 // The serviceLabel is used to look up the service name from the labels on the
-// container. Containers without this label or with an empty serviceLabel are
-// ignored.
+// container. Containers without this label or a port label are ignored.
+//
+// Ports may be assigned to other services with labels of the form
+// `beacon.port.<port>.service`. Each such service is sent as a separate
+// container.
 //
-// If stopOnClose is true then stop events will be queued for each running
-// container when Close is called.
-func New(endpoint string, hostIP, serviceLabel string, stopOnClose bool) (beacon.Runtime, error) {
+// Paused containers are stopped and started again when unpaused. Containers
+// are also stopped when they are killed with a terminating signal so that
+// consumers may drain them before they exit. A killed container which sends
+// any other event before it dies, such as a health check, survived the signal
+// and is started again. Running out of memory sends an OOM event but does not
+// stop a container on its own; it is stopped when it dies. Renaming,
+// updating, or changing the networks of a running container sends an Update.
+//
+// If the connection to Docker is lost the runtime reconnects with backoff and
+// lists the running containers again. Start events are sent for the running
+// containers and Stop events for those which stopped while disconnected. The
+// first listing is retried the same way if it fails.
+func New(endpoint, hostIP, serviceLabel string, options Options) (beacon.Runtime, error) {
 	client, err := dockerclient.NewClient(endpoint)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create docker client")
@@ -34,30 +99,40 @@ func New(endpoint string, hostIP, serviceLabel string, stopOnClose bool) (beacon
 	}
 
 	return &docker{
-		endpoint:     endpoint,
-		client:       client,
-		hostIP:       hostIP,
-		serviceLabel: serviceLabel,
-		stopOnClose:  stopOnClose,
-		wg:           &sync.WaitGroup{},
-		stop:         make(chan struct{}),
+		endpoint:            endpoint,
+		client:              client,
+		hostIP:              hostIP,
+		hostIPv6:            options.HostIPv6,
+		serviceLabel:        serviceLabel,
+		stopOnClose:         options.StopOnClose,
+		waitForHealthy:      options.WaitForHealthy,
+		excludeUnnamedPorts: options.ExcludeUnnamedPorts,
+		backoff:             ReconnectBackoff,
+		maxBackoff:          ReconnectMaxBackoff,
+		wg:                  &sync.WaitGroup{},
+		stop:                make(chan struct{}),
 	}, nil
 }
 
 // docker implements a Beacon runtime for the Docker daemon.
 type docker struct {
-	endpoint     string
-	client       *dockerclient.Client
-	hostIP       string
-	serviceLabel string
-	stopOnClose  bool
-	wg           *sync.WaitGroup
-	stop         chan struct{}
+	endpoint            string
+	client              *dockerclient.Client
+	hostIP              string
+	hostIPv6            string
+	serviceLabel        string
+	stopOnClose         bool
+	waitForHealthy      bool
+	excludeUnnamedPorts bool
+	backoff             time.Duration
+	maxBackoff          time.Duration
+	wg                  *sync.WaitGroup
+	stop                chan struct{}
 }
 
 // EmitEvents sends Docker events to Beacon.
 func (d *docker) EmitEvents() (<-chan *beacon.Event, error) {
-	dockerEvents := make(chan *dockerclient.APIEvents, 1)
+	dockerEvents := make(chan *dockerclient.APIEvents, eventBuffer)
 	if err := d.client.AddEventListener(dockerEvents); err != nil {
 		return nil, errors.Wrap(err, "failed to listen for docker events")
 	}
@@ -68,27 +143,30 @@ func (d *docker) EmitEvents() (<-chan *beacon.Event, error) {
 		defer d.wg.Done()
 		defer close(beaconEvents)
 
-		running := map[string]struct{}{}
+		// running maps the ID of each running container to the IDs of the
+		// service containers sent for it.
+		running := map[string][]string{}
+
+		// killed holds the IDs of containers stopped by a kill signal which
+		// have not died. They may have survived the signal.
+		killed := map[string]struct{}{}
 
 		defer func() {
 			if d.stopOnClose {
-				for id := range running {
-					beaconEvents <- &beacon.Event{
-						Action: beacon.Stop,
-						Container: &beacon.Container{
-							ID: id,
-						},
+				for _, ids := range running {
+					for _, id := range ids {
+						beaconEvents <- &beacon.Event{
+							Action: beacon.Stop,
+							Container: &beacon.Container{
+								ID: id,
+							},
+						}
 					}
 				}
 			}
 		}()
 
-		sendStart := func(cntr *beacon.Container) bool {
-			running[cntr.ID] = struct{}{}
-			beaconEvent := &beacon.Event{
-				Action:    beacon.Start,
-				Container: cntr,
-			}
+		send := func(beaconEvent *beacon.Event) bool {
 			select {
 			case beaconEvents <- beaconEvent:
 			case <-d.stop:
@@ -97,52 +175,237 @@ func (d *docker) EmitEvents() (<-chan *beacon.Event, error) {
 			return true
 		}
 
-		sendStop := func(id string) bool {
-			if _, ok := running[id]; ok {
-				delete(running, id)
+		// sendServices sends an event for each service of a container. Stops
+		// are sent for services which the container no longer provides.
+		sendServices := func(action beacon.Action, cntr *beacon.Container) bool {
+			services := d.splitServices(cntr)
+			ids := make([]string, len(services))
+			sent := make(map[string]struct{}, len(services))
+			for n, service := range services {
+				ids[n] = service.ID
+				sent[service.ID] = struct{}{}
+				if !send(&beacon.Event{Action: action, Container: service}) {
+					return false
+				}
 			}
-			beaconEvent := &beacon.Event{
-				Action: beacon.Stop,
-				Container: &beacon.Container{
-					ID: id,
-				},
+			for _, id := range running[cntr.ID] {
+				if _, ok := sent[id]; !ok {
+					if !send(&beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: id}}) {
+						return false
+					}
+				}
 			}
-			select {
-			case beaconEvents <- beaconEvent:
-			case <-d.stop:
-				return false
+			running[cntr.ID] = ids
+			return true
+		}
+
+		sendStart := func(cntr *beacon.Container) bool {
+			delete(killed, cntr.ID)
+			return sendServices(beacon.Start, cntr)
+		}
+
+		// sendInspected inspects a container and sends a start if it is ready.
+		sendInspected := func(id string) bool {
+			container, err := d.inspectContainer(id)
+			if err == errContainerIgnored || err == errContainerNotReady {
+				return true
+			} else if err != nil {
+				Logger.Printf("failed to inspect container %s: %s", id, err)
+				return true
+			}
+			return sendStart(container)
+		}
+
+		// sendUpdate inspects a running container and sends an update.
+		sendUpdate := func(id string) bool {
+			if _, ok := running[id]; !ok {
+				return true
+			}
+			container, err := d.inspectContainer(id)
+			if err == errContainerIgnored || err == errContainerNotReady {
+				return true
+			} else if err != nil {
+				Logger.Printf("failed to inspect container %s: %s", id, err)
+				return true
+			}
+			return sendServices(beacon.Update, container)
+		}
+
+		// sendOOM warns that a running container ran out of memory.
+		sendOOM := func(id string) bool {
+			for _, id := range running[id] {
+				if !send(&beacon.Event{Action: beacon.OOM, Container: &beacon.Container{ID: id}}) {
+					return false
+				}
 			}
 			return true
 		}
 
-		// get and queue existing containers
-		containers, err := d.listContainers()
-		if err != nil {
-			Logger.Print(err)
+		// sendStop sends a stop for a running container. A container may send
+		// several stopping events, e.g. kill and die, so only the first is sent.
+		sendStop := func(id string) bool {
+			ids, ok := running[id]
+			if !ok {
+				return true
+			}
+			delete(running, id)
+			for _, id := range ids {
+				if !send(&beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: id}}) {
+					return false
+				}
+			}
+			return true
+		}
+
+		// resync sends a start for each running container and a stop for each
+		// container which is no longer running. Starts are sent for containers
+		// which were already running so that Beacon sees any changes to them.
+		resync := func() (bool, error) {
+			containers, err := d.listContainers()
+			if err != nil {
+				return true, err
+			}
+			listed := make(map[string]struct{}, len(containers))
+			for _, container := range containers {
+				listed[container.ID] = struct{}{}
+				if !sendStart(container) {
+					return false, nil
+				}
+			}
+			for id := range running {
+				if _, ok := listed[id]; !ok {
+					if !sendStop(id) {
+						return false, nil
+					}
+				}
+			}
+			for id := range killed {
+				if _, ok := listed[id]; !ok {
+					delete(killed, id)
+				}
+			}
+			return true, nil
 		}
-		for _, container := range containers {
-			if !sendStart(container) {
+
+		// reconnect listens for docker events and resyncs. It retries with
+		// backoff until it succeeds and returns nil if the runtime is closed.
+		// A non-nil `events` is a listener which is still open and is used
+		// for the first attempt.
+		reconnect := func(events chan *dockerclient.APIEvents) chan *dockerclient.APIEvents {
+			delay := d.backoff
+			for {
+				select {
+				case <-time.After(delay):
+				case <-d.stop:
+					if events != nil {
+						d.client.RemoveEventListener(events)
+					}
+					return nil
+				}
+
+				var err error
+				if events == nil {
+					events = make(chan *dockerclient.APIEvents, eventBuffer)
+					if err = d.client.AddEventListener(events); err != nil {
+						events = nil
+					}
+				}
+				if events != nil {
+					var ok bool
+					if ok, err = resync(); !ok {
+						d.client.RemoveEventListener(events)
+						return nil
+					} else if err == nil {
+						Logger.Printf("reconnected to docker on %s", d.endpoint)
+						return events
+					}
+					d.client.RemoveEventListener(events)
+					events = nil
+				}
+
+				if delay *= 2; delay > d.maxBackoff {
+					delay = d.maxBackoff
+				}
+				Logger.Printf("failed to reconnect to docker on %s, retrying in %s: %s", d.endpoint, delay, err)
+			}
+		}
+
+		// get and queue existing containers, retrying like a lost connection
+		// until they are listed
+		ok, err := resync()
+		if !ok {
+			return
+		} else if err != nil {
+			Logger.Printf("failed to list containers on %s, retrying in %s: %s", d.endpoint, d.backoff, err)
+			if dockerEvents = reconnect(dockerEvents); dockerEvents == nil {
 				return
 			}
 		}
+		select {
+		case beaconEvents <- &beacon.Event{Action: beacon.Synced}:
+		case <-d.stop:
+			return
+		}
 
 		for {
 			select {
 			case dockerEvent, ok := <-dockerEvents:
 				if !ok {
-					return
+					Logger.Printf("lost connection to docker on %s", d.endpoint)
+					if dockerEvents = reconnect(nil); dockerEvents == nil {
+						return
+					}
+					continue
+				}
+				id, action := dockerEvent.Actor.ID, dockerEvent.Action
+				switch dockerEvent.Type {
+				case "", "container":
+				case "network":
+					// network events are reported against the network
+					id, action = dockerEvent.Actor.Attributes["container"], "network "+action
+				default:
+					continue
 				}
-				switch dockerEvent.Action {
-				case "start":
-					if container, err := d.inspectContainer(dockerEvent.Actor.ID); err == nil {
-						if !sendStart(container) {
+				if _, ok := killed[id]; ok {
+					switch action {
+					case "kill", "oom", "pause", "health_status: unhealthy", "stop", "die", "destroy":
+					default:
+						// the container survived the kill signal
+						if !sendInspected(id) {
 							return
 						}
-					} else if err != errContainerIgnored {
-						Logger.Printf("failed to inspect container %s: %s", dockerEvent.Actor.ID, err)
+						continue
 					}
-				case "stop", "die":
-					if !sendStop(dockerEvent.Actor.ID) {
+				}
+				if !d.waitForHealthy && strings.HasPrefix(action, "health_status") {
+					continue
+				}
+				switch action {
+				case "start", "unpause", "health_status: healthy":
+					if !sendInspected(id) {
+						return
+					}
+				case "rename", "update", "network connect", "network disconnect":
+					if !sendUpdate(id) {
+						return
+					}
+				case "oom":
+					if !sendOOM(id) {
+						return
+					}
+				case "kill":
+					if _, ok := stopSignals[dockerEvent.Actor.Attributes["signal"]]; !ok {
+						continue
+					}
+					if _, ok := running[id]; ok {
+						killed[id] = struct{}{}
+					}
+					if !sendStop(id) {
+						return
+					}
+				case "pause", "health_status: unhealthy", "stop", "die":
+					delete(killed, id)
+					if !sendStop(id) {
 						return
 					}
 				}
@@ -170,7 +433,7 @@ func (d *docker) listContainers() ([]*beacon.Container, error) {
 	containers := make([]*beacon.Container, 0, len(apiContainers))
 	for _, apiContainer := range apiContainers {
 		container, err := d.inspectContainer(apiContainer.ID)
-		if err == errContainerIgnored {
+		if err == errContainerIgnored || err == errContainerNotReady {
 			continue
 		} else if err != nil {
 			return nil, errors.Wrap(err, "failed to list containers")
@@ -187,9 +450,13 @@ func (d *docker) inspectContainer(id string) (*beacon.Container, error) {
 	}
 
 	service, ok := dockerContainer.Config.Labels[d.serviceLabel]
-	if !ok {
+	if !ok && len(portServices(dockerContainer.Config.Labels)) == 0 {
+		metrics.Ignored.Inc()
 		return nil, errContainerIgnored
 	}
+	if dockerContainer.State.Paused || (d.waitForHealthy && !healthy(dockerContainer)) {
+		return nil, errContainerNotReady
+	}
 
 	bindings := make([]*beacon.Binding, 0, len(dockerContainer.HostConfig.PortBindings))
 	for dockerPort, dockerBindings := range dockerContainer.NetworkSettings.Ports {
@@ -199,8 +466,14 @@ func (d *docker) inspectContainer(id string) (*beacon.Container, error) {
 		}
 		for _, dockerBinding := range dockerBindings {
 			hostIP := dockerBinding.HostIP
-			if hostIP == "0.0.0.0" {
+			switch hostIP {
+			case "0.0.0.0":
 				hostIP = d.hostIP
+			case "::":
+				if d.hostIPv6 == "" {
+					continue
+				}
+				hostIP = d.hostIPv6
 			}
 			hostPort, err := strconv.Atoi(dockerBinding.HostPort)
 			if err != nil {
@@ -214,15 +487,50 @@ func (d *docker) inspectContainer(id string) (*beacon.Container, error) {
 			})
 		}
 	}
+	sortBindings(bindings)
+
+	// unbound ports are reachable on the host when using the host network
+	ports, err := unboundPorts(dockerContainer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect container %s", id)
+	}
+	if dockerContainer.HostConfig.NetworkMode == "host" {
+		for _, hostIP := range []string{d.hostIP, d.hostIPv6} {
+			if hostIP == "" {
+				continue
+			}
+			for _, port := range ports {
+				bindings = append(bindings, &beacon.Binding{
+					HostIP:        hostIP,
+					HostPort:      port.ContainerPort,
+					ContainerPort: port.ContainerPort,
+					Protocol:      port.Protocol,
+				})
+			}
+		}
+		ports = []*beacon.Port{}
+	}
 
 	return &beacon.Container{
 		ID:       dockerContainer.ID,
 		Service:  service,
 		Labels:   dockerContainer.Config.Labels,
 		Bindings: bindings,
+		Ports:    ports,
+		Networks: parseNetworks(dockerContainer),
 	}, nil
 }
 
+// healthy returns false if the container has a health check which has not
+// passed.
+func healthy(container *dockerclient.Container) bool {
+	switch container.State.Health.Status {
+	case "", "none", "healthy":
+		return true
+	}
+	return false
+}
+
 // Close the connection to Docker and stop emiting events.
 func (d *docker) Close() error {
 	close(d.stop)
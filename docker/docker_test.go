@@ -17,15 +17,19 @@ const (
 	DOCKER_IMAGE_TAG  = "latest"
 )
 
+// WaitForEvents waits for `n` container events. Synced events are skipped.
 func WaitForEvents(ch <-chan *beacon.Event, n int, timeout time.Duration) ([]*beacon.Event, error) {
 	events := make([]*beacon.Event, 0, n)
 	timer := time.After(timeout)
-	for i := 0; i < n; i++ {
+	for len(events) < n {
 		select {
 		case event, ok := <-ch:
 			if !ok {
 				return events, errors.New("channel closed")
 			}
+			if event.Action == beacon.Synced {
+				continue
+			}
 			events = append(events, event)
 		case <-timer:
 			return events, errors.New("timed out")
@@ -176,7 +180,7 @@ func TestNew(t *testing.T) {
 	defer daemon.Close()
 
 	hostIP := "10.1.1.100"
-	runtime, err := docker.New(daemon.URL(), hostIP, "service", false)
+	runtime, err := docker.New(daemon.URL(), hostIP, "service", docker.Options{})
 	if err != nil {
 		t.Error(err)
 	} else if err := runtime.Close(); err != nil {
@@ -193,7 +197,7 @@ func TestOneContainer(t *testing.T) {
 	defer daemon.Close()
 
 	hostIP := "10.1.1.100"
-	runtime, err := docker.New(daemon.URL(), hostIP, "service", false)
+	runtime, err := docker.New(daemon.URL(), hostIP, "service", docker.Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -275,7 +279,7 @@ func TestTwoContainers(t *testing.T) {
 	defer daemon.Close()
 
 	hostIP := "10.1.1.100"
-	runtime, err := docker.New(daemon.URL(), hostIP, "service", false)
+	runtime, err := docker.New(daemon.URL(), hostIP, "service", docker.Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -367,7 +371,7 @@ func TestRunIgnoredContainers(t *testing.T) {
 	defer daemon.Close()
 
 	hostIP := "10.1.1.100"
-	runtime, err := docker.New(daemon.URL(), hostIP, "service", false)
+	runtime, err := docker.New(daemon.URL(), hostIP, "service", docker.Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -462,7 +466,7 @@ func TestExistingContainer(t *testing.T) {
 	defer daemon.Close()
 
 	hostIP := "10.1.1.100"
-	runtime, err := docker.New(daemon.URL(), hostIP, "service", false)
+	runtime, err := docker.New(daemon.URL(), hostIP, "service", docker.Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -550,7 +554,7 @@ func TestStopOnClose(t *testing.T) {
 	defer daemon.Close()
 
 	hostIP := "10.1.1.100"
-	runtime, err := docker.New(daemon.URL(), hostIP, "service", true)
+	runtime, err := docker.New(daemon.URL(), hostIP, "service", docker.Options{StopOnClose: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -617,3 +621,50 @@ func TestStopOnClose(t *testing.T) {
 		}
 	}
 }
+
+func TestSynced(t *testing.T) {
+	t.Parallel()
+	daemon, err := DockerSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+
+	hostIP := "10.1.1.100"
+	runtime, err := docker.New(daemon.URL(), hostIP, "service", docker.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+
+	labels := map[string]string{
+		"service": "test",
+		"test":    "TestSynced",
+	}
+	id, err := StartContainer(daemon, []*beacon.Binding{}, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer StopContainer(daemon, id)
+
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timer := time.After(30 * time.Second)
+	wantActions := []beacon.Action{beacon.Start, beacon.Synced}
+	for n, wantAction := range wantActions {
+		select {
+		case event := <-ch:
+			if event.Action != wantAction {
+				t.Fatalf("event %d: action %s != %s", n, event.Action, wantAction)
+			}
+			if wantAction == beacon.Start && event.Container.ID != id {
+				t.Errorf("event %d: container %s != %s", n, event.Container.ID, id)
+			}
+		case <-timer:
+			t.Fatal("timed out waiting for events")
+		}
+	}
+}
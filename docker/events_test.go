@@ -0,0 +1,91 @@
+package docker_test
+
+import (
+	docker "."
+	"github.com/BlueDragonX/beacon/beacon"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"testing"
+	"time"
+)
+
+func TestContainerEvents(t *testing.T) {
+	t.Parallel()
+	daemon := NewFakeDocker()
+	defer daemon.Close()
+	daemon.SetContainers(map[string]string{"a": "www", "b": "www"})
+
+	runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.WaitForConnect(t)
+	if _, err := WaitForEvents(ch, 2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon.SendEvent("pause", "a")
+	checkEvents(t, ch, wantEvent{beacon.Stop, "a"})
+	daemon.SendEvent("unpause", "a")
+	checkEvents(t, ch, wantEvent{beacon.Start, "a"})
+
+	daemon.SendEvent("rename", "a")
+	daemon.SendEvent("update", "a")
+	daemon.Send(&dockerclient.APIEvents{
+		Type:   "network",
+		Action: "connect",
+		Actor: dockerclient.APIActor{
+			ID:         "net",
+			Attributes: map[string]string{"container": "b"},
+		},
+	})
+	checkEvents(t, ch,
+		wantEvent{beacon.Update, "a"},
+		wantEvent{beacon.Update, "a"},
+		wantEvent{beacon.Update, "b"},
+	)
+
+	// SIGHUP does not stop a container so only the SIGTERM is sent
+	for _, signal := range []string{"1", "15"} {
+		daemon.Send(&dockerclient.APIEvents{
+			Type:   "container",
+			Action: "kill",
+			Actor: dockerclient.APIActor{
+				ID:         "a",
+				Attributes: map[string]string{"signal": signal},
+			},
+		})
+	}
+	checkEvents(t, ch, wantEvent{beacon.Stop, "a"})
+
+	// a container which sends another event survived the signal
+	daemon.SendEvent("exec_start: sh", "a")
+	checkEvents(t, ch, wantEvent{beacon.Start, "a"})
+
+	// updates are not sent for stopped containers
+	daemon.Send(&dockerclient.APIEvents{
+		Type:   "container",
+		Action: "kill",
+		Actor: dockerclient.APIActor{
+			ID:         "a",
+			Attributes: map[string]string{"signal": "15"},
+		},
+	})
+	daemon.SendEvent("die", "a")
+	daemon.SendEvent("rename", "a")
+	checkEvents(t, ch, wantEvent{beacon.Stop, "a"})
+
+	// a container which runs out of memory keeps running until it dies
+	daemon.SendEvent("oom", "b")
+	daemon.SendEvent("rename", "b")
+	daemon.SendEvent("die", "b")
+	checkEvents(t, ch,
+		wantEvent{beacon.OOM, "b"},
+		wantEvent{beacon.Update, "b"},
+		wantEvent{beacon.Stop, "b"},
+	)
+}
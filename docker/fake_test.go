@@ -0,0 +1,184 @@
+package docker_test
+
+import (
+	"encoding/json"
+	"github.com/BlueDragonX/beacon/beacon"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var apiVersion = regexp.MustCompile(`^/v[0-9.]+`)
+
+// FakeDocker serves the parts of the Docker API used by the runtime. Event
+// streams stay open and send the events passed to SendEvent until Disconnect
+// is called.
+type FakeDocker struct {
+	*httptest.Server
+	Connects   chan struct{}
+	events     chan *dockerclient.APIEvents
+	lock       sync.Mutex
+	containers map[string]*dockerclient.Container
+	failLists  int
+	disconnect chan struct{}
+}
+
+func NewFakeDocker() *FakeDocker {
+	d := &FakeDocker{
+		Connects:   make(chan struct{}, 16),
+		events:     make(chan *dockerclient.APIEvents, 16),
+		containers: map[string]*dockerclient.Container{},
+		disconnect: make(chan struct{}),
+	}
+	d.Server = httptest.NewServer(http.HandlerFunc(d.serve))
+	return d
+}
+
+// SetContainers replaces the running containers. Each container is labeled
+// with its service.
+func (d *FakeDocker) SetContainers(services map[string]string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.containers = map[string]*dockerclient.Container{}
+	for id, service := range services {
+		d.containers[id] = &dockerclient.Container{
+			ID:              id,
+			Config:          &dockerclient.Config{Labels: map[string]string{"service": service}},
+			HostConfig:      &dockerclient.HostConfig{},
+			NetworkSettings: &dockerclient.NetworkSettings{},
+		}
+	}
+}
+
+// AddContainer adds a running container.
+func (d *FakeDocker) AddContainer(container *dockerclient.Container) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.containers[container.ID] = container
+}
+
+// FailLists makes the next `n` requests to list containers fail.
+func (d *FakeDocker) FailLists(n int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.failLists = n
+}
+
+// SetHealth sets the health status of a container.
+func (d *FakeDocker) SetHealth(id, status string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.containers[id].State.Health.Status = status
+}
+
+// SendEvent sends a container event with the given action.
+func (d *FakeDocker) SendEvent(action, id string) {
+	d.Send(&dockerclient.APIEvents{
+		Type:   "container",
+		Action: action,
+		Actor:  dockerclient.APIActor{ID: id},
+	})
+}
+
+// Send sends an event to the open event streams.
+func (d *FakeDocker) Send(event *dockerclient.APIEvents) {
+	event.Time = time.Now().Unix()
+	d.events <- event
+}
+
+// Disconnect closes the open event streams.
+func (d *FakeDocker) Disconnect() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	close(d.disconnect)
+	d.disconnect = make(chan struct{})
+}
+
+// Close disconnects the event streams and stops the server.
+func (d *FakeDocker) Close() {
+	d.Disconnect()
+	d.Server.Close()
+}
+
+// WaitForConnect waits for the runtime to open an event stream.
+func (d *FakeDocker) WaitForConnect(t *testing.T) {
+	select {
+	case <-d.Connects:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event stream")
+	}
+}
+
+func (d *FakeDocker) serve(w http.ResponseWriter, r *http.Request) {
+	path := apiVersion.ReplaceAllString(r.URL.Path, "")
+	switch {
+	case path == "/events":
+		d.lock.Lock()
+		disconnect := d.disconnect
+		d.lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		d.Connects <- struct{}{}
+		encoder := json.NewEncoder(w)
+		for {
+			select {
+			case event := <-d.events:
+				encoder.Encode(event)
+				w.(http.Flusher).Flush()
+			case <-disconnect:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	case path == "/containers/json":
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if d.failLists > 0 {
+			d.failLists--
+			http.Error(w, "test error", http.StatusInternalServerError)
+			return
+		}
+		list := []dockerclient.APIContainers{}
+		for id := range d.containers {
+			list = append(list, dockerclient.APIContainers{ID: id})
+		}
+		json.NewEncoder(w).Encode(list)
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+		container, ok := d.containers[id]
+		if !ok {
+			http.Error(w, "no such container", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(container)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type wantEvent struct {
+	action beacon.Action
+	id     string
+}
+
+// checkEvents waits for container events and compares their actions and IDs.
+func checkEvents(t *testing.T, ch <-chan *beacon.Event, wantEvents ...wantEvent) {
+	haveEvents, err := WaitForEvents(ch, len(wantEvents), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range wantEvents {
+		if have := haveEvents[n]; have.Action != want.action || have.Container.ID != want.id {
+			t.Errorf("have event %s %s, want %s %s", have.Action, have.Container.ID, want.action, want.id)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package docker_test
+
+import (
+	docker "."
+	"github.com/BlueDragonX/beacon/beacon"
+	"testing"
+)
+
+func TestWaitForHealthy(t *testing.T) {
+	t.Parallel()
+	daemon := NewFakeDocker()
+	defer daemon.Close()
+	daemon.SetContainers(map[string]string{"a": "www", "b": "www"})
+	daemon.SetHealth("a", "starting")
+
+	runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{WaitForHealthy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b has no health check and starts immediately
+	checkEvents(t, ch, wantEvent{beacon.Start, "b"})
+
+	daemon.SetHealth("a", "healthy")
+	daemon.SendEvent("health_status: healthy", "a")
+	checkEvents(t, ch, wantEvent{beacon.Start, "a"})
+
+	daemon.SetHealth("a", "unhealthy")
+	daemon.SendEvent("health_status: unhealthy", "a")
+	checkEvents(t, ch, wantEvent{beacon.Stop, "a"})
+
+	daemon.SetHealth("a", "healthy")
+	daemon.SendEvent("health_status: healthy", "a")
+	checkEvents(t, ch, wantEvent{beacon.Start, "a"})
+}
+
+func TestIgnoreHealth(t *testing.T) {
+	t.Parallel()
+	daemon := NewFakeDocker()
+	defer daemon.Close()
+	daemon.SetContainers(map[string]string{"a": "www"})
+	daemon.SetHealth("a", "starting")
+
+	runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, ch, wantEvent{beacon.Start, "a"})
+
+	// the unhealthy event is ignored so the stop is the next event
+	daemon.SetHealth("a", "unhealthy")
+	daemon.SendEvent("health_status: unhealthy", "a")
+	daemon.SendEvent("stop", "a")
+	checkEvents(t, ch, wantEvent{beacon.Stop, "a"})
+}
@@ -0,0 +1,141 @@
+package docker
+
+import (
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Host IP settings which are resolved to an address by ResolveHostIP.
+const (
+	HostIPAuto = "auto" // Use the address of an interface or of the default route.
+	HostIPEC2  = "ec2"  // Use the address reported by the EC2 metadata service.
+)
+
+// EC2MetadataURL is the base URL of the EC2 instance metadata service.
+var EC2MetadataURL = "http://169.254.169.254/latest"
+
+// ec2Client is used to query the EC2 metadata service.
+var ec2Client = &http.Client{Timeout: 2 * time.Second}
+
+// ResolveHostIP returns the address to advertise for bindings which listen on
+// all host addresses. The hostIP is either an IP address, which is returned as
+// is, or one of HostIPAuto or HostIPEC2.
+//
+// When hostIP is HostIPAuto the first address of the interface named `iface`
+// is used. If `iface` is empty the address of the interface with the default
+// route is used instead.
+//
+// An IPv6 address is returned if ipv6 is true and an IPv4 address otherwise.
+func ResolveHostIP(hostIP, iface string, ipv6 bool) (string, error) {
+	var ip net.IP
+	var err error
+	switch hostIP {
+	case HostIPAuto:
+		if iface == "" {
+			ip, err = routeIP(ipv6)
+		} else {
+			ip, err = interfaceIP(iface, ipv6)
+		}
+	case HostIPEC2:
+		ip, err = ec2IP(ipv6)
+	default:
+		if ip = net.ParseIP(hostIP); ip == nil {
+			err = errors.Errorf("invalid host IP %q", hostIP)
+		}
+	}
+	if err != nil {
+		return "", err
+	} else if (ip.To4() == nil) != ipv6 {
+		return "", errors.Errorf("host IP %s has the wrong address family", ip)
+	}
+	return ip.String(), nil
+}
+
+// routeIP returns the local address used to reach the default route. No
+// packets are sent.
+func routeIP(ipv6 bool) (net.IP, error) {
+	network, address := "udp4", "192.0.2.1:9"
+	if ipv6 {
+		network, address = "udp6", "[2001:db8::1]:9"
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find the default route")
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// interfaceIP returns the first address of the named interface. Link local
+// IPv6 addresses are skipped.
+func interfaceIP(name string, ipv6 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find interface %s", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list addresses of interface %s", name)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipv6 && ipNet.IP.To4() == nil && !ipNet.IP.IsLinkLocalUnicast() {
+			return ipNet.IP, nil
+		} else if !ipv6 && ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, errors.Errorf("interface %s has no matching address", name)
+}
+
+// ec2IP reads the instance's private IPv4 or its IPv6 address from the EC2
+// metadata service. A session token is used if the service provides one.
+func ec2IP(ipv6 bool) (net.IP, error) {
+	token := ""
+	req, err := http.NewRequest("PUT", EC2MetadataURL+"/api/token", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query ec2 metadata")
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	if res, err := ec2Client.Do(req); err == nil {
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			token = string(body)
+		}
+	}
+
+	path := "/meta-data/local-ipv4"
+	if ipv6 {
+		path = "/meta-data/ipv6"
+	}
+	if req, err = http.NewRequest("GET", EC2MetadataURL+path, nil); err != nil {
+		return nil, errors.Wrap(err, "failed to query ec2 metadata")
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	res, err := ec2Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query ec2 metadata")
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query ec2 metadata")
+	} else if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to query ec2 metadata: %s", res.Status)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, errors.Errorf("invalid ec2 metadata address %q", body)
+	}
+	return ip, nil
+}
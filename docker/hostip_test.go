@@ -0,0 +1,79 @@
+package docker_test
+
+import (
+	docker "."
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveHostIP(t *testing.T) {
+	tests := []struct {
+		hostIP string
+		iface  string
+		ipv6   bool
+		want   string
+	}{
+		{hostIP: "10.1.1.100", want: "10.1.1.100"},
+		{hostIP: "fd00::100", ipv6: true, want: "fd00::100"},
+		{hostIP: docker.HostIPAuto, iface: "lo", want: "127.0.0.1"},
+	}
+	for _, test := range tests {
+		have, err := docker.ResolveHostIP(test.hostIP, test.iface, test.ipv6)
+		if err != nil {
+			t.Errorf("%s: %s", test.hostIP, err)
+		} else if have != test.want {
+			t.Errorf("%s: have %s, want %s", test.hostIP, have, test.want)
+		}
+	}
+}
+
+func TestResolveHostIPInvalid(t *testing.T) {
+	tests := []struct {
+		hostIP string
+		iface  string
+		ipv6   bool
+	}{
+		{hostIP: "localhost"},
+		{hostIP: "fd00::100"},
+		{hostIP: "10.1.1.100", ipv6: true},
+		{hostIP: docker.HostIPAuto, iface: "nonexistent0"},
+	}
+	for _, test := range tests {
+		if have, err := docker.ResolveHostIP(test.hostIP, test.iface, test.ipv6); err == nil {
+			t.Errorf("%s: have %s, want error", test.hostIP, have)
+		}
+	}
+}
+
+func TestResolveHostIPEC2(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "token":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/local-ipv4":
+			w.Write([]byte("172.31.5.10"))
+		case r.URL.Path == "/latest/meta-data/ipv6":
+			w.Write([]byte("2600:1f18::10"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defaultURL := docker.EC2MetadataURL
+	docker.EC2MetadataURL = server.URL + "/latest"
+	defer func() { docker.EC2MetadataURL = defaultURL }()
+
+	if have, err := docker.ResolveHostIP(docker.HostIPEC2, "", false); err != nil {
+		t.Error(err)
+	} else if have != "172.31.5.10" {
+		t.Errorf("have %s, want 172.31.5.10", have)
+	}
+	if have, err := docker.ResolveHostIP(docker.HostIPEC2, "", true); err != nil {
+		t.Error(err)
+	} else if have != "2600:1f18::10" {
+		t.Errorf("have %s, want 2600:1f18::10", have)
+	}
+}
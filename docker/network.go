@@ -2,7 +2,9 @@ package docker
 
 import (
 	"github.com/BlueDragonX/beacon/beacon"
+	dockerclient "github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -29,3 +31,76 @@ func parsePort(port string) (number int, protocol beacon.Protocol, err error) {
 	}
 	return
 }
+
+// unboundPorts returns the ports which the container exposes but does not
+// bind on the host. Ports are sorted by number and protocol.
+func unboundPorts(container *dockerclient.Container) ([]*beacon.Port, error) {
+	exposed := map[dockerclient.Port]struct{}{}
+	if container.Config != nil {
+		for port := range container.Config.ExposedPorts {
+			exposed[port] = struct{}{}
+		}
+	}
+	if container.NetworkSettings != nil {
+		for port, bindings := range container.NetworkSettings.Ports {
+			if len(bindings) == 0 {
+				exposed[port] = struct{}{}
+			} else {
+				delete(exposed, port)
+			}
+		}
+	}
+
+	ports := make([]*beacon.Port, 0, len(exposed))
+	for exposedPort := range exposed {
+		number, protocol, err := parsePort(string(exposedPort))
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, &beacon.Port{ContainerPort: number, Protocol: protocol})
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].ContainerPort == ports[j].ContainerPort {
+			return ports[i].Protocol < ports[j].Protocol
+		}
+		return ports[i].ContainerPort < ports[j].ContainerPort
+	})
+	return ports, nil
+}
+
+// sortBindings sorts bindings by container port, protocol, host IP and host
+// port.
+func sortBindings(bindings []*beacon.Binding) {
+	sort.Slice(bindings, func(i, j int) bool {
+		a, b := bindings[i], bindings[j]
+		if a.ContainerPort != b.ContainerPort {
+			return a.ContainerPort < b.ContainerPort
+		} else if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		} else if a.HostIP != b.HostIP {
+			return a.HostIP < b.HostIP
+		}
+		return a.HostPort < b.HostPort
+	})
+}
+
+// parseNetworks returns the networks the container is attached to sorted by
+// name.
+func parseNetworks(container *dockerclient.Container) []*beacon.Network {
+	if container.NetworkSettings == nil {
+		return []*beacon.Network{}
+	}
+	networks := make([]*beacon.Network, 0, len(container.NetworkSettings.Networks))
+	for name, network := range container.NetworkSettings.Networks {
+		networks = append(networks, &beacon.Network{
+			Name:    name,
+			IP:      network.IPAddress,
+			IPv6:    network.GlobalIPv6Address,
+			Aliases: network.Aliases,
+		})
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Name < networks[j].Name
+	})
+	return networks
+}
@@ -0,0 +1,216 @@
+package docker_test
+
+import (
+	docker "."
+	"github.com/BlueDragonX/beacon/beacon"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"testing"
+	"time"
+)
+
+func TestNetworks(t *testing.T) {
+	t.Parallel()
+	daemon := NewFakeDocker()
+	defer daemon.Close()
+	daemon.AddContainer(&dockerclient.Container{
+		ID: "a",
+		Config: &dockerclient.Config{
+			Labels: map[string]string{"service": "www"},
+			ExposedPorts: map[dockerclient.Port]struct{}{
+				"80/tcp":   {},
+				"8080/tcp": {},
+			},
+		},
+		HostConfig: &dockerclient.HostConfig{NetworkMode: "backend"},
+		NetworkSettings: &dockerclient.NetworkSettings{
+			Ports: map[dockerclient.Port][]dockerclient.PortBinding{
+				"80/tcp":   {{HostIP: "0.0.0.0", HostPort: "32768"}},
+				"8080/tcp": nil,
+			},
+			Networks: map[string]dockerclient.ContainerNetwork{
+				"frontend": {IPAddress: "172.19.0.2"},
+				"backend":  {IPAddress: "172.18.0.2", GlobalIPv6Address: "fd00::2", Aliases: []string{"www"}},
+			},
+		},
+	})
+	daemon.AddContainer(&dockerclient.Container{
+		ID: "b",
+		Config: &dockerclient.Config{
+			Labels: map[string]string{"service": "dns"},
+			ExposedPorts: map[dockerclient.Port]struct{}{
+				"53/udp": {},
+				"53/tcp": {},
+			},
+		},
+		HostConfig: &dockerclient.HostConfig{NetworkMode: "host"},
+		NetworkSettings: &dockerclient.NetworkSettings{
+			Networks: map[string]dockerclient.ContainerNetwork{"host": {}},
+		},
+	})
+
+	runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	haveEvents, err := WaitForEvents(ch, 2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantContainers := map[string]*beacon.Container{
+		"a": {
+			ID:      "a",
+			Service: "www",
+			Labels:  map[string]string{"service": "www"},
+			Bindings: []*beacon.Binding{
+				{HostIP: "10.1.1.100", HostPort: 32768, ContainerPort: 80, Protocol: beacon.TCP},
+			},
+			Ports: []*beacon.Port{
+				{ContainerPort: 8080, Protocol: beacon.TCP},
+			},
+			Networks: []*beacon.Network{
+				{Name: "backend", IP: "172.18.0.2", IPv6: "fd00::2", Aliases: []string{"www"}},
+				{Name: "frontend", IP: "172.19.0.2"},
+			},
+		},
+		"b": {
+			ID:      "b",
+			Service: "dns",
+			Labels:  map[string]string{"service": "dns"},
+			Bindings: []*beacon.Binding{
+				{HostIP: "10.1.1.100", HostPort: 53, ContainerPort: 53, Protocol: beacon.TCP},
+				{HostIP: "10.1.1.100", HostPort: 53, ContainerPort: 53, Protocol: beacon.UDP},
+			},
+			Ports: []*beacon.Port{},
+			Networks: []*beacon.Network{
+				{Name: "host"},
+			},
+		},
+	}
+	for _, event := range haveEvents {
+		want := wantContainers[event.Container.ID]
+		if !event.Container.Equal(want) {
+			t.Errorf("have container %+v, want %+v", event.Container, want)
+		}
+	}
+}
+
+func TestHostIPv6(t *testing.T) {
+	t.Parallel()
+	daemon := NewFakeDocker()
+	defer daemon.Close()
+	daemon.AddContainer(&dockerclient.Container{
+		ID:         "a",
+		Config:     &dockerclient.Config{Labels: map[string]string{"service": "www"}},
+		HostConfig: &dockerclient.HostConfig{},
+		NetworkSettings: &dockerclient.NetworkSettings{
+			Ports: map[dockerclient.Port][]dockerclient.PortBinding{
+				"80/tcp": {
+					{HostIP: "0.0.0.0", HostPort: "32768"},
+					{HostIP: "::", HostPort: "32768"},
+				},
+			},
+		},
+	})
+
+	tests := []struct {
+		hostIPv6 string
+		want     []*beacon.Binding
+	}{
+		{
+			// :: bindings are dropped without a host IPv6 address
+			hostIPv6: "",
+			want: []*beacon.Binding{
+				{HostIP: "10.1.1.100", HostPort: 32768, ContainerPort: 80, Protocol: beacon.TCP},
+			},
+		},
+		{
+			hostIPv6: "fd00::100",
+			want: []*beacon.Binding{
+				{HostIP: "10.1.1.100", HostPort: 32768, ContainerPort: 80, Protocol: beacon.TCP},
+				{HostIP: "fd00::100", HostPort: 32768, ContainerPort: 80, Protocol: beacon.TCP},
+			},
+		},
+	}
+	for _, test := range tests {
+		runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{HostIPv6: test.hostIPv6})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch, err := runtime.EmitEvents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		haveEvents, err := WaitForEvents(ch, 1, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &beacon.Container{
+			ID:       "a",
+			Service:  "www",
+			Labels:   map[string]string{"service": "www"},
+			Bindings: test.want,
+		}
+		if have := haveEvents[0].Container; !have.Equal(want) {
+			t.Errorf("hostIPv6 %q: have bindings %+v, want %+v", test.hostIPv6, have.Bindings, want.Bindings)
+		}
+		runtime.Close()
+	}
+}
+
+func TestBindingOrder(t *testing.T) {
+	t.Parallel()
+	daemon := NewFakeDocker()
+	defer daemon.Close()
+	daemon.AddContainer(&dockerclient.Container{
+		ID:         "a",
+		Config:     &dockerclient.Config{Labels: map[string]string{"service": "www"}},
+		HostConfig: &dockerclient.HostConfig{},
+		NetworkSettings: &dockerclient.NetworkSettings{
+			Ports: map[dockerclient.Port][]dockerclient.PortBinding{
+				"443/tcp": {{HostIP: "0.0.0.0", HostPort: "443"}},
+				"80/tcp": {
+					{HostIP: "10.1.1.101", HostPort: "80"},
+					{HostIP: "0.0.0.0", HostPort: "8080"},
+				},
+				"53/udp": {{HostIP: "0.0.0.0", HostPort: "53"}},
+				"53/tcp": {{HostIP: "0.0.0.0", HostPort: "53"}},
+			},
+		},
+	})
+
+	want := []*beacon.Binding{
+		{HostIP: "10.1.1.100", HostPort: 53, ContainerPort: 53, Protocol: beacon.TCP},
+		{HostIP: "10.1.1.100", HostPort: 53, ContainerPort: 53, Protocol: beacon.UDP},
+		{HostIP: "10.1.1.100", HostPort: 8080, ContainerPort: 80, Protocol: beacon.TCP},
+		{HostIP: "10.1.1.101", HostPort: 80, ContainerPort: 80, Protocol: beacon.TCP},
+		{HostIP: "10.1.1.100", HostPort: 443, ContainerPort: 443, Protocol: beacon.TCP},
+	}
+	// map iteration order varies so inspect the container several times
+	for n := 0; n < 5; n++ {
+		runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch, err := runtime.EmitEvents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		haveEvents, err := WaitForEvents(ch, 1, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := haveEvents[0].Container.Bindings
+		for i := range want {
+			if len(have) != len(want) || !have[i].Equal(want[i]) {
+				t.Fatalf("have bindings %+v, want %+v", have, want)
+			}
+		}
+		runtime.Close()
+	}
+}
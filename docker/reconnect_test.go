@@ -0,0 +1,69 @@
+package docker_test
+
+import (
+	docker "."
+	"github.com/BlueDragonX/beacon/beacon"
+	"testing"
+	"time"
+)
+
+func init() {
+	docker.ReconnectBackoff = 10 * time.Millisecond
+}
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+	daemon := NewFakeDocker()
+	defer daemon.Close()
+	daemon.SetContainers(map[string]string{"a": "www"})
+
+	runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.WaitForConnect(t)
+
+	checkEvents(t, ch, wantEvent{beacon.Start, "a"})
+
+	// a stops and b starts while the daemon is unreachable
+	daemon.SetContainers(map[string]string{"b": "www"})
+	daemon.Disconnect()
+	daemon.WaitForConnect(t)
+	checkEvents(t, ch, wantEvent{beacon.Start, "b"}, wantEvent{beacon.Stop, "a"})
+}
+
+func TestInitialSyncRetry(t *testing.T) {
+	t.Parallel()
+	daemon := NewFakeDocker()
+	defer daemon.Close()
+	daemon.SetContainers(map[string]string{"a": "www"})
+	daemon.FailLists(1)
+
+	runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the listing is retried and followed by a Synced event
+	want := []beacon.Action{beacon.Start, beacon.Synced}
+	for _, action := range want {
+		select {
+		case event := <-ch:
+			if event.Action != action {
+				t.Fatalf("have event %s, want %s", event.Action, action)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", action)
+		}
+	}
+}
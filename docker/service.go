@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"github.com/BlueDragonX/beacon/beacon"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Port labels assign a container port to a service. The label key surrounds
+// the port number with a prefix and suffix, e.g. `beacon.port.8080.service`.
+const (
+	portLabelPrefix = "beacon.port."
+	portLabelSuffix = ".service"
+)
+
+// portServices returns the services named by a container's port labels keyed
+// by port number. Labels with an invalid port are ignored.
+func portServices(labels map[string]string) map[int]string {
+	services := map[int]string{}
+	for key, service := range labels {
+		if !strings.HasPrefix(key, portLabelPrefix) || !strings.HasSuffix(key, portLabelSuffix) || service == "" {
+			continue
+		}
+		port, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(key, portLabelPrefix), portLabelSuffix))
+		if err != nil {
+			continue
+		}
+		services[port] = service
+	}
+	return services
+}
+
+// splitServices returns a container for each service which the container
+// provides. The container's own service keeps its ID and the ports which are
+// not named by a port label. Other services are given the ID of the container
+// followed by a colon and the service name, e.g. `512b64138152:www-metrics`,
+// and the ports named for them.
+func (d *docker) splitServices(container *beacon.Container) []*beacon.Container {
+	ports := portServices(container.Labels)
+	if len(ports) == 0 && !d.excludeUnnamedPorts {
+		return []*beacon.Container{container}
+	}
+
+	services := map[string]*beacon.Container{}
+	service := func(name string) *beacon.Container {
+		if cntr, ok := services[name]; ok {
+			return cntr
+		}
+		cntr := container.Copy()
+		cntr.Service = name
+		cntr.Bindings = []*beacon.Binding{}
+		cntr.Ports = []*beacon.Port{}
+		if name != container.Service {
+			cntr.ID = container.ID + ":" + name
+		}
+		services[name] = cntr
+		return cntr
+	}
+	// portService returns the service of a port or false if it is excluded
+	portService := func(port int) (string, bool) {
+		if name, ok := ports[port]; ok {
+			return name, true
+		}
+		return container.Service, container.Service != "" && !d.excludeUnnamedPorts
+	}
+
+	if container.Service != "" {
+		service(container.Service)
+	}
+	for _, binding := range container.Bindings {
+		if name, ok := portService(binding.ContainerPort); ok {
+			cntr := service(name)
+			cntr.Bindings = append(cntr.Bindings, binding.Copy())
+		}
+	}
+	for _, port := range container.Ports {
+		if name, ok := portService(port.ContainerPort); ok {
+			cntr := service(name)
+			cntr.Ports = append(cntr.Ports, port.Copy())
+		}
+	}
+
+	split := make([]*beacon.Container, 0, len(services))
+	for _, cntr := range services {
+		split = append(split, cntr)
+	}
+	sort.Slice(split, func(i, j int) bool {
+		return split[i].ID < split[j].ID
+	})
+	return split
+}
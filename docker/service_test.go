@@ -0,0 +1,104 @@
+package docker_test
+
+import (
+	docker "."
+	"github.com/BlueDragonX/beacon/beacon"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"testing"
+	"time"
+)
+
+func TestPortServices(t *testing.T) {
+	t.Parallel()
+	labels := map[string]string{
+		"service":                     "www",
+		"beacon.port.9090.service":    "www-metrics",
+		"beacon.port.invalid.service": "ignored",
+	}
+
+	tests := []struct {
+		excludeUnnamedPorts bool
+		want                []*beacon.Container
+	}{
+		{
+			excludeUnnamedPorts: false,
+			want: []*beacon.Container{
+				{
+					ID:      "a",
+					Service: "www",
+					Labels:  labels,
+					Bindings: []*beacon.Binding{
+						{HostIP: "10.1.1.100", HostPort: 32768, ContainerPort: 80, Protocol: beacon.TCP},
+					},
+					Ports: []*beacon.Port{{ContainerPort: 9100, Protocol: beacon.TCP}},
+				},
+				{
+					ID:      "a:www-metrics",
+					Service: "www-metrics",
+					Labels:  labels,
+					Bindings: []*beacon.Binding{
+						{HostIP: "10.1.1.100", HostPort: 32769, ContainerPort: 9090, Protocol: beacon.TCP},
+					},
+				},
+			},
+		},
+		{
+			excludeUnnamedPorts: true,
+			want: []*beacon.Container{
+				{
+					ID:      "a",
+					Service: "www",
+					Labels:  labels,
+				},
+				{
+					ID:      "a:www-metrics",
+					Service: "www-metrics",
+					Labels:  labels,
+					Bindings: []*beacon.Binding{
+						{HostIP: "10.1.1.100", HostPort: 32769, ContainerPort: 9090, Protocol: beacon.TCP},
+					},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		daemon := NewFakeDocker()
+		defer daemon.Close()
+		daemon.AddContainer(&dockerclient.Container{
+			ID:         "a",
+			Config:     &dockerclient.Config{Labels: labels},
+			HostConfig: &dockerclient.HostConfig{},
+			NetworkSettings: &dockerclient.NetworkSettings{
+				Ports: map[dockerclient.Port][]dockerclient.PortBinding{
+					"80/tcp":   {{HostIP: "0.0.0.0", HostPort: "32768"}},
+					"9090/tcp": {{HostIP: "0.0.0.0", HostPort: "32769"}},
+					"9100/tcp": nil,
+				},
+			},
+		})
+
+		runtime, err := docker.New(daemon.URL, "10.1.1.100", "service", docker.Options{ExcludeUnnamedPorts: test.excludeUnnamedPorts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch, err := runtime.EmitEvents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		daemon.WaitForConnect(t)
+		haveEvents, err := WaitForEvents(ch, len(test.want), 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n, want := range test.want {
+			if have := haveEvents[n].Container; !have.Equal(want) {
+				t.Errorf("excludeUnnamedPorts=%t: have container %+v, want %+v", test.excludeUnnamedPorts, have, want)
+			}
+		}
+
+		// stopping the container stops each service
+		daemon.SendEvent("die", "a")
+		checkEvents(t, ch, wantEvent{beacon.Stop, "a"}, wantEvent{beacon.Stop, "a:www-metrics"})
+		runtime.Close()
+	}
+}
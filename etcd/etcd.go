@@ -0,0 +1,258 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is used when the lease TTL is zero.
+const DefaultTTL = 30 * time.Second
+
+var (
+	// RequestTimeout limits each request to etcd.
+	RequestTimeout = 5 * time.Second
+
+	// LeaseBackoff is how long the backend waits before granting a new lease
+	// after the old one expires. The wait doubles after each failed attempt.
+	LeaseBackoff = time.Second
+
+	// LeaseMaxBackoff is the longest the backend waits between attempts to
+	// grant a new lease.
+	LeaseMaxBackoff = 30 * time.Second
+)
+
+// New creates an etcd backend which writes events to the etcd cluster at
+// `endpoints`. Each running container is stored as a JSON encoded event in
+// the key `<prefix>/<service>/<id>`. Keys are overwritten when a container
+// is updated and deleted when it stops.
+//
+// Keys are attached to a lease with the given `ttl` which the backend keeps
+// alive. If Beacon or its host dies the keys expire once the lease does. If
+// the lease is lost, for instance because etcd was unreachable for longer
+// than the ttl, a new lease is granted and the keys are written again.
+//
+// The backend is a beacon.Syncer. Once the runtime has reported its running
+// containers their keys are written under the backend's lease, so that keys
+// written before Beacon restarted do not expire with the old lease.
+func New(endpoints []string, prefix string, ttl time.Duration) (beacon.Backend, error) {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	} else if ttl < time.Second {
+		return nil, errors.Errorf("invalid ttl %s", ttl)
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: RequestTimeout,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create etcd client")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &etcd{
+		client: client,
+		prefix: strings.TrimSuffix(prefix, "/"),
+		ttl:    ttl,
+		lock:   &sync.Mutex{},
+		keys:   map[string]string{},
+		values: map[string]string{},
+		wg:     &sync.WaitGroup{},
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// etcd stores container events in etcd.
+type etcd struct {
+	client *clientv3.Client
+	prefix string
+	ttl    time.Duration
+	lock   *sync.Mutex
+	lease  clientv3.LeaseID
+	keys   map[string]string // keys by container ID
+	values map[string]string // values by key
+	wg     *sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ProcessEvent writes the key of a started or updated container and deletes
+// the key of a stopped container. OOM events are ignored.
+func (e *etcd) ProcessEvent(event *beacon.Event) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	id := event.Container.ID
+	switch event.Action {
+	case beacon.Stop:
+		return e.delete(id)
+	case beacon.OOM:
+		return nil
+	}
+	return e.put(event)
+}
+
+// Sync writes the keys of the containers which this process has not written.
+// Keys of containers which stopped while Beacon was down expire with the
+// lease of the process which wrote them.
+func (e *etcd) Sync(containers []*beacon.Container) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, container := range containers {
+		if _, ok := e.keys[container.ID]; ok {
+			continue
+		}
+		if err := e.put(&beacon.Event{Action: beacon.Start, Container: container}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put writes the event to the key of its container. It must be called with the
+// lock held.
+func (e *etcd) put(event *beacon.Event) error {
+	id := event.Container.ID
+	value, err := json.Marshal(event)
+	if err != nil {
+		return beacon.Permanent(errors.Wrap(err, "failed to serialize event"))
+	}
+	key := e.prefix + "/" + event.Container.Service + "/" + id
+	if oldKey, ok := e.keys[id]; ok && oldKey != key {
+		// the container's service changed
+		if err := e.delete(id); err != nil {
+			return err
+		}
+	}
+	if err := e.grant(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, RequestTimeout)
+	defer cancel()
+	if _, err := e.client.Put(ctx, key, string(value), clientv3.WithLease(e.lease)); err != nil {
+		return errors.Wrapf(err, "failed to put key %s", key)
+	}
+	e.keys[id] = key
+	e.values[key] = string(value)
+	return nil
+}
+
+// delete removes the key of a container. If the key is not known, such as
+// after a restart, the keys under the prefix are searched for it.
+func (e *etcd) delete(id string) error {
+	ctx, cancel := context.WithTimeout(e.ctx, RequestTimeout)
+	defer cancel()
+
+	keys := []string{}
+	if key, ok := e.keys[id]; ok {
+		keys = append(keys, key)
+	} else {
+		res, err := e.client.Get(ctx, e.prefix+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		if err != nil {
+			return errors.Wrapf(err, "failed to find key of container %s", id)
+		}
+		for _, kv := range res.Kvs {
+			// keys have the form <prefix>/<service>/<id>
+			parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), e.prefix+"/"), "/", 2)
+			if len(parts) == 2 && parts[1] == id {
+				keys = append(keys, string(kv.Key))
+			}
+		}
+	}
+
+	for _, key := range keys {
+		if _, err := e.client.Delete(ctx, key); err != nil {
+			return errors.Wrapf(err, "failed to delete key %s", key)
+		}
+		delete(e.values, key)
+	}
+	delete(e.keys, id)
+	return nil
+}
+
+// grant creates a lease and keeps it alive if there is no current lease. It
+// must be called with the lock held.
+func (e *etcd) grant() error {
+	if e.lease != clientv3.NoLease {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, RequestTimeout)
+	defer cancel()
+	lease, err := e.client.Grant(ctx, int64(e.ttl/time.Second))
+	if err != nil {
+		return errors.Wrap(err, "failed to grant lease")
+	}
+	keepAlive, err := e.client.KeepAlive(e.ctx, lease.ID)
+	if err != nil {
+		return errors.Wrap(err, "failed to keep lease alive")
+	}
+	e.lease = lease.ID
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		for range keepAlive {
+		}
+		if e.ctx.Err() == nil {
+			Logger.Printf("etcd lease %x expired", lease.ID)
+			e.restore(lease.ID)
+		}
+	}()
+	return nil
+}
+
+// restore grants a new lease after the `expired` one is lost and writes the
+// keys again. It retries with backoff until it succeeds or the backend is
+// closed.
+func (e *etcd) restore(expired clientv3.LeaseID) {
+	delay := LeaseBackoff
+	for {
+		err := func() error {
+			e.lock.Lock()
+			defer e.lock.Unlock()
+			if e.lease == expired {
+				e.lease = clientv3.NoLease
+			}
+			if err := e.grant(); err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(e.ctx, RequestTimeout)
+			defer cancel()
+			for key, value := range e.values {
+				if _, err := e.client.Put(ctx, key, value, clientv3.WithLease(e.lease)); err != nil {
+					return errors.Wrapf(err, "failed to put key %s", key)
+				}
+			}
+			return nil
+		}()
+		if err == nil {
+			return
+		}
+
+		Logger.Printf("failed to restore etcd keys, retrying in %s: %s", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-e.ctx.Done():
+			return
+		}
+		if delay *= 2; delay > LeaseMaxBackoff {
+			delay = LeaseMaxBackoff
+		}
+	}
+}
+
+// Close stops keeping the lease alive and closes the client. The lease is
+// deliberately not revoked so that the keys survive a restart of Beacon. They
+// expire along with the lease unless a new process writes them again.
+func (e *etcd) Close() error {
+	e.cancel()
+	e.wg.Wait()
+	return e.client.Close()
+}
@@ -0,0 +1,295 @@
+package etcd_test
+
+import (
+	etcd "."
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/BlueDragonX/beacon/beacon"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func init() {
+	etcd.LeaseBackoff = 10 * time.Millisecond
+}
+
+// freeURL returns an http URL on a free local port.
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// StartEtcd starts an embedded etcd server. The returned function stops the
+// server and removes its data.
+func StartEtcd(t *testing.T) (endpoint string, stop func()) {
+	dir, err := ioutil.TempDir("", "beacon-etcd-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		server.Close()
+		os.RemoveAll(dir)
+		t.Fatal("timed out waiting for etcd")
+	}
+	return clientURL.String(), func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// NewClient creates a client used to inspect the server.
+func NewClient(t *testing.T, endpoint string) *clientv3.Client {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// getKeys returns the values of the keys under the prefix.
+func getKeys(t *testing.T, client *clientv3.Client, prefix string) map[string]string {
+	res, err := client.Get(context.Background(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs := make(map[string]string, len(res.Kvs))
+	for _, kv := range res.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return kvs
+}
+
+// waitForKeys polls the prefix until it holds `n` keys.
+func waitForKeys(t *testing.T, client *clientv3.Client, prefix string, n int) map[string]string {
+	var kvs map[string]string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if kvs = getKeys(t, client, prefix); len(kvs) == n {
+			return kvs
+		}
+	}
+	t.Fatalf("have %d keys, want %d", len(kvs), n)
+	return nil
+}
+
+func newEvent(action beacon.Action, id, service string) *beacon.Event {
+	return &beacon.Event{
+		Action: action,
+		Container: &beacon.Container{
+			ID:      id,
+			Service: service,
+			Labels:  map[string]string{"service": service},
+			Bindings: []*beacon.Binding{
+				{HostIP: "10.1.1.100", HostPort: 54392, ContainerPort: 80, Protocol: beacon.TCP},
+			},
+		},
+	}
+}
+
+func TestEtcd(t *testing.T) {
+	endpoint, stop := StartEtcd(t)
+	defer stop()
+	client := NewClient(t, endpoint)
+	defer client.Close()
+
+	backend, err := etcd.New([]string{endpoint}, "/beacon/", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	events := []*beacon.Event{
+		newEvent(beacon.Start, "a", "www"),
+		newEvent(beacon.Start, "b", "www"),
+		newEvent(beacon.Update, "a", "www"),
+		newEvent(beacon.Stop, "b", ""),
+	}
+	events[2].Container.Labels["color"] = "red"
+	for _, event := range events {
+		if err := backend.ProcessEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kvs := getKeys(t, client, "/beacon/")
+	if len(kvs) != 1 {
+		t.Fatalf("have keys %v, want /beacon/www/a", kvs)
+	}
+	event := &beacon.Event{}
+	if err := json.Unmarshal([]byte(kvs["/beacon/www/a"]), event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Action != beacon.Update || !event.Container.Equal(events[2].Container) {
+		t.Errorf("have event %s %+v, want %s %+v", event.Action, event.Container, events[2].Action, events[2].Container)
+	}
+
+	res, err := client.Get(context.Background(), "/beacon/www/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := client.TimeToLive(context.Background(), clientv3.LeaseID(res.Kvs[0].Lease))
+	if err != nil {
+		t.Fatal(err)
+	} else if ttl.GrantedTTL != 10 {
+		t.Errorf("have lease ttl %d, want 10", ttl.GrantedTTL)
+	}
+}
+
+func TestEtcdStopAfterRestart(t *testing.T) {
+	endpoint, stop := StartEtcd(t)
+	defer stop()
+	client := NewClient(t, endpoint)
+	defer client.Close()
+
+	backend, err := etcd.New([]string{endpoint}, "/beacon", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.ProcessEvent(newEvent(beacon.Start, "docker-0/a", "www")); err != nil {
+		t.Fatal(err)
+	}
+	backend.Close()
+
+	// a new backend does not know the key of the container
+	backend, err = etcd.New([]string{endpoint}, "/beacon", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	if err := backend.ProcessEvent(newEvent(beacon.Stop, "docker-0/a", "")); err != nil {
+		t.Fatal(err)
+	}
+	if kvs := getKeys(t, client, "/beacon/"); len(kvs) != 0 {
+		t.Errorf("have keys %v after stop, want none", kvs)
+	}
+}
+
+func TestEtcdLeaseExpires(t *testing.T) {
+	endpoint, stop := StartEtcd(t)
+	defer stop()
+	client := NewClient(t, endpoint)
+	defer client.Close()
+
+	backend, err := etcd.New([]string{endpoint}, "/beacon", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.ProcessEvent(newEvent(beacon.Start, "a", "www")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lease is kept alive past its ttl
+	time.Sleep(2 * time.Second)
+	waitForKeys(t, client, "/beacon/", 1)
+
+	// the keys expire once the backend stops keeping the lease alive
+	backend.Close()
+	waitForKeys(t, client, "/beacon/", 0)
+}
+
+func TestEtcdLeaseRestored(t *testing.T) {
+	endpoint, stop := StartEtcd(t)
+	defer stop()
+	client := NewClient(t, endpoint)
+	defer client.Close()
+
+	backend, err := etcd.New([]string{endpoint}, "/beacon", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	for n := 0; n < 3; n++ {
+		if err := backend.ProcessEvent(newEvent(beacon.Start, fmt.Sprint(n), "www")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// revoking the lease deletes the keys and the backend writes them again
+	res, err := client.Get(context.Background(), "/beacon/www/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease := clientv3.LeaseID(res.Kvs[0].Lease)
+	if _, err := client.Revoke(context.Background(), lease); err != nil {
+		t.Fatal(err)
+	}
+	waitForKeys(t, client, "/beacon/", 3)
+	res, err = client.Get(context.Background(), "/beacon/www/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Kvs) != 1 || clientv3.LeaseID(res.Kvs[0].Lease) == lease {
+		t.Errorf("key was not written with a new lease")
+	}
+}
+
+func TestEtcdSync(t *testing.T) {
+	endpoint, stop := StartEtcd(t)
+	defer stop()
+	client := NewClient(t, endpoint)
+	defer client.Close()
+
+	backend, err := etcd.New([]string{endpoint}, "/beacon", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := backend.ProcessEvent(newEvent(beacon.Start, id, "www")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend.Close()
+	res, err := client.Get(context.Background(), "/beacon/www/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease := clientv3.LeaseID(res.Kvs[0].Lease)
+
+	// a new backend moves the keys of running containers to its own lease
+	backend, err = etcd.New([]string{endpoint}, "/beacon", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	running := newEvent(beacon.Start, "a", "www").Container
+	if err := backend.(beacon.Syncer).Sync([]*beacon.Container{running}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Revoke(context.Background(), lease); err != nil {
+		t.Fatal(err)
+	}
+	kvs := getKeys(t, client, "/beacon/")
+	if _, ok := kvs["/beacon/www/a"]; !ok || len(kvs) != 1 {
+		t.Errorf("have keys %v, want /beacon/www/a", kvs)
+	}
+}
@@ -0,0 +1,223 @@
+package file
+
+import (
+	"bytes"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// New creates a runtime which reads containers from the file at `path`. The
+// file is checked for changes every `interval`.
+//
+// The file holds a YAML or JSON list of containers. Each container has an
+// `id`, a `service`, and optional `labels` and `bindings`. Each binding has a
+// `host-ip`, `host-port`, `container-port` and `protocol`. The container port
+// defaults to the host port and the protocol to tcp.
+//
+// When the file changes a Start event is sent for each new container, an
+// Update event for each changed container, and a Stop event for each removed
+// container. Changes which fail to parse are logged and ignored. The file
+// should be replaced by renaming a new file over it so that a partially
+// written file is never read.
+func New(path string, interval time.Duration) (beacon.Runtime, error) {
+	if path == "" {
+		return nil, errors.New("invalid path")
+	}
+	if interval <= 0 {
+		return nil, errors.Errorf("invalid interval %s", interval)
+	}
+	return &file{
+		path:     path,
+		interval: interval,
+		wg:       &sync.WaitGroup{},
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// file implements a Beacon runtime for containers listed in a file.
+type file struct {
+	path     string
+	interval time.Duration
+	wg       *sync.WaitGroup
+	stop     chan struct{}
+}
+
+// fileBinding is a binding as it appears in the file.
+type fileBinding struct {
+	HostIP        string `yaml:"host-ip"`
+	HostPort      int    `yaml:"host-port"`
+	ContainerPort int    `yaml:"container-port"`
+	Protocol      string
+}
+
+// fileContainer is a container as it appears in the file.
+type fileContainer struct {
+	ID       string
+	Service  string
+	Labels   map[string]string
+	Bindings []fileBinding
+}
+
+// EmitEvents sends events for the containers in the file. The file is read
+// once before returning so that an invalid file is reported immediately.
+func (f *file) EmitEvents() (<-chan *beacon.Event, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", f.path)
+	}
+	containers, err := parse(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", f.path)
+	}
+	beaconEvents := make(chan *beacon.Event, 1)
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer close(beaconEvents)
+
+		send := func(events []*beacon.Event) bool {
+			for _, event := range events {
+				select {
+				case beaconEvents <- event:
+				case <-f.stop:
+					return false
+				}
+			}
+			return true
+		}
+
+		events := append(diff(nil, containers), &beacon.Event{Action: beacon.Synced})
+		if !send(events) {
+			return
+		}
+		current := containers
+
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-f.stop:
+				return
+			}
+
+			newData, err := ioutil.ReadFile(f.path)
+			if err != nil {
+				Logger.Printf("failed to read %s: %s", f.path, err)
+				continue
+			} else if bytes.Equal(newData, data) {
+				continue
+			}
+			data = newData
+			containers, err := parse(data)
+			if err != nil {
+				Logger.Printf("failed to parse %s: %s", f.path, err)
+				continue
+			}
+			if !send(diff(current, containers)) {
+				return
+			}
+			current = containers
+		}
+	}()
+
+	Logger.Printf("watching %s for containers", f.path)
+	return beaconEvents, nil
+}
+
+// parse reads a list of containers.
+func parse(data []byte) ([]*beacon.Container, error) {
+	fileContainers := []fileContainer{}
+	if err := yaml.Unmarshal(data, &fileContainers); err != nil {
+		return nil, err
+	}
+
+	ids := map[string]struct{}{}
+	containers := make([]*beacon.Container, 0, len(fileContainers))
+	for n, fileContainer := range fileContainers {
+		if fileContainer.ID == "" {
+			return nil, errors.Errorf("container %d has no id", n)
+		} else if fileContainer.Service == "" {
+			return nil, errors.Errorf("container %s has no service", fileContainer.ID)
+		} else if _, ok := ids[fileContainer.ID]; ok {
+			return nil, errors.Errorf("container %s is listed more than once", fileContainer.ID)
+		}
+		ids[fileContainer.ID] = struct{}{}
+
+		bindings := make([]*beacon.Binding, len(fileContainer.Bindings))
+		for n, fileBinding := range fileContainer.Bindings {
+			binding := &beacon.Binding{
+				HostIP:        fileBinding.HostIP,
+				HostPort:      fileBinding.HostPort,
+				ContainerPort: fileBinding.ContainerPort,
+				Protocol:      beacon.Protocol(fileBinding.Protocol),
+			}
+			if binding.ContainerPort == 0 {
+				binding.ContainerPort = binding.HostPort
+			}
+			switch binding.Protocol {
+			case "":
+				binding.Protocol = beacon.TCP
+			case beacon.TCP, beacon.UDP:
+			default:
+				return nil, errors.Errorf("container %s has unsupported protocol %s", fileContainer.ID, binding.Protocol)
+			}
+			bindings[n] = binding
+		}
+
+		labels := fileContainer.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		containers = append(containers, &beacon.Container{
+			ID:       fileContainer.ID,
+			Service:  fileContainer.Service,
+			Labels:   labels,
+			Bindings: bindings,
+		})
+	}
+	return containers, nil
+}
+
+// diff returns the events which change the `from` containers into the `to`
+// containers. Starts and updates are in the order of `to`. Stops follow,
+// ordered by ID.
+func diff(from, to []*beacon.Container) []*beacon.Event {
+	old := make(map[string]*beacon.Container, len(from))
+	for _, container := range from {
+		old[container.ID] = container
+	}
+
+	events := []*beacon.Event{}
+	for _, container := range to {
+		if oldContainer, ok := old[container.ID]; !ok {
+			events = append(events, &beacon.Event{Action: beacon.Start, Container: container})
+		} else if !container.Equal(oldContainer) {
+			events = append(events, &beacon.Event{Action: beacon.Update, Container: container})
+		}
+		delete(old, container.ID)
+	}
+
+	stopped := make([]string, 0, len(old))
+	for id := range old {
+		stopped = append(stopped, id)
+	}
+	sort.Strings(stopped)
+	for _, id := range stopped {
+		events = append(events, &beacon.Event{Action: beacon.Stop, Container: &beacon.Container{ID: id}})
+	}
+	return events
+}
+
+// Close stops watching the file and emitting events.
+func (f *file) Close() error {
+	close(f.stop)
+	f.wg.Wait()
+	return nil
+}
@@ -0,0 +1,165 @@
+package file_test
+
+import (
+	file "."
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// WaitForEvents waits for `n` events.
+func WaitForEvents(ch <-chan *beacon.Event, n int, timeout time.Duration) ([]*beacon.Event, error) {
+	events := make([]*beacon.Event, 0, n)
+	timer := time.After(timeout)
+	for len(events) < n {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return events, errors.New("channel closed")
+			}
+			events = append(events, event)
+		case <-timer:
+			return events, errors.New("timed out")
+		}
+	}
+	return events, nil
+}
+
+// writeFile replaces the file at `path` so that the runtime never reads a
+// partial write.
+func writeFile(t *testing.T, path, data string) {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkEvents(t *testing.T, ch <-chan *beacon.Event, wantEvents []*beacon.Event) {
+	haveEvents, err := WaitForEvents(ch, len(wantEvents), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(haveEvents, wantEvents) {
+		t.Errorf("have events:")
+		for _, event := range haveEvents {
+			t.Errorf("  %s %+v", event.Action, event.Container)
+		}
+		t.Errorf("want events:")
+		for _, event := range wantEvents {
+			t.Errorf("  %s %+v", event.Action, event.Container)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beacon-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "containers.yml")
+
+	writeFile(t, path, `
+- id: a
+  service: www
+  labels:
+    env: prod
+  bindings:
+  - host-ip: 10.0.0.5
+    host-port: 8080
+    container-port: 80
+- id: b
+  service: db
+`)
+	runtime, err := file.New(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &beacon.Container{
+		ID:      "a",
+		Service: "www",
+		Labels:  map[string]string{"env": "prod"},
+		Bindings: []*beacon.Binding{
+			{HostIP: "10.0.0.5", HostPort: 8080, ContainerPort: 80, Protocol: beacon.TCP},
+		},
+	}
+	b := &beacon.Container{ID: "b", Service: "db", Labels: map[string]string{}, Bindings: []*beacon.Binding{}}
+	checkEvents(t, ch, []*beacon.Event{
+		{Action: beacon.Start, Container: a},
+		{Action: beacon.Start, Container: b},
+		{Action: beacon.Synced},
+	})
+
+	// invalid changes are ignored
+	writeFile(t, path, `[{"id": "a"}]`)
+	time.Sleep(50 * time.Millisecond)
+
+	// a is removed, b is changed, c is added
+	writeFile(t, path, `[
+		{"id": "b", "service": "db", "labels": {"env": "dev"}},
+		{"id": "c", "service": "dns", "bindings": [{"host-ip": "10.0.0.6", "host-port": 53, "protocol": "udp"}]}
+	]`)
+	b = &beacon.Container{ID: "b", Service: "db", Labels: map[string]string{"env": "dev"}, Bindings: []*beacon.Binding{}}
+	c := &beacon.Container{
+		ID:      "c",
+		Service: "dns",
+		Labels:  map[string]string{},
+		Bindings: []*beacon.Binding{
+			{HostIP: "10.0.0.6", HostPort: 53, ContainerPort: 53, Protocol: beacon.UDP},
+		},
+	}
+	checkEvents(t, ch, []*beacon.Event{
+		{Action: beacon.Update, Container: b},
+		{Action: beacon.Start, Container: c},
+		{Action: beacon.Stop, Container: &beacon.Container{ID: "a"}},
+	})
+
+	select {
+	case event := <-ch:
+		t.Errorf("unexpected event %s %+v", event.Action, event.Container)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFileInvalid(t *testing.T) {
+	tests := []string{
+		`- id: a`,
+		`- service: www`,
+		`[{"id": "a", "service": "www"}, {"id": "a", "service": "db"}]`,
+		`[{"id": "a", "service": "www", "bindings": [{"host-port": 80, "protocol": "sctp"}]}]`,
+		`not a list`,
+	}
+
+	dir, err := ioutil.TempDir("", "beacon-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "containers.json")
+
+	for _, test := range tests {
+		writeFile(t, path, test)
+		runtime, err := file.New(path, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runtime.EmitEvents(); err == nil {
+			t.Errorf("file %q is valid", test)
+		}
+		runtime.Close()
+	}
+}
@@ -0,0 +1,181 @@
+package kubernetes
+
+import (
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/BlueDragonX/beacon/metrics"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"sync"
+)
+
+// New creates a Kubernetes runtime which watches the pods scheduled on
+// `node`. The client is configured from the `kubeconfig` file or, if it is
+// empty, from the service account of the pod Beacon runs in.
+//
+// The service of a pod is read from its `serviceLabel` label. Pods without
+// the label have their service read from the `serviceAnnotation` annotation
+// instead. Pods with neither are ignored.
+//
+// A Start event is sent when a pod becomes ready and a Stop event when it is
+// no longer ready or is deleted. Each pod is reported as a single container
+// whose bindings are the ports of all of the pod's containers.
+func New(kubeconfig, node, serviceLabel, serviceAnnotation string) (beacon.Runtime, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load kubernetes config")
+	}
+	client, err := k8s.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kubernetes client")
+	}
+	return NewWithClient(client, node, serviceLabel, serviceAnnotation)
+}
+
+// NewWithClient works like New but uses the provided Kubernetes `client`.
+func NewWithClient(client k8s.Interface, node, serviceLabel, serviceAnnotation string) (beacon.Runtime, error) {
+	if node == "" {
+		return nil, errors.New("invalid node")
+	}
+	if serviceLabel == "" && serviceAnnotation == "" {
+		return nil, errors.New("one of serviceLabel or serviceAnnotation is required")
+	}
+	return &kubernetes{
+		client:            client,
+		node:              node,
+		serviceLabel:      serviceLabel,
+		serviceAnnotation: serviceAnnotation,
+		wg:                &sync.WaitGroup{},
+		stop:              make(chan struct{}),
+	}, nil
+}
+
+// kubernetes implements a Beacon runtime for the pods on a Kubernetes node.
+type kubernetes struct {
+	client            k8s.Interface
+	node              string
+	serviceLabel      string
+	serviceAnnotation string
+	wg                *sync.WaitGroup
+	stop              chan struct{}
+}
+
+// EmitEvents sends pod events to Beacon.
+func (k *kubernetes) EmitEvents() (<-chan *beacon.Event, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(k.client, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", k.node).String()
+		}),
+	)
+	informer := factory.Core().V1().Pods().Informer()
+	beaconEvents := make(chan *beacon.Event, 1)
+
+	// ready holds the IDs of the pods which were sent a Start event. The
+	// informer calls the handlers one at a time so it needs no lock.
+	ready := map[string]struct{}{}
+
+	send := func(event *beacon.Event) {
+		select {
+		case beaconEvents <- event:
+		case <-k.stop:
+		}
+	}
+
+	sendStop := func(id string) {
+		if _, ok := ready[id]; ok {
+			delete(ready, id)
+			send(&beacon.Event{
+				Action: beacon.Stop,
+				Container: &beacon.Container{
+					ID: id,
+				},
+			})
+		}
+	}
+
+	handle := func(pod *corev1.Pod, added bool) {
+		if pod.Spec.NodeName != k.node {
+			return
+		}
+		id := string(pod.UID)
+		if !podReady(pod) {
+			sendStop(id)
+			return
+		}
+		container, err := k.podContainer(pod)
+		if err == nil {
+			ready[id] = struct{}{}
+			send(&beacon.Event{
+				Action:    beacon.Start,
+				Container: container,
+			})
+			return
+		} else if err == errPodIgnored {
+			if added {
+				metrics.Ignored.Inc()
+			}
+		} else {
+			Logger.Print(err)
+		}
+		sendStop(id)
+	}
+
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				handle(pod, true)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				handle(pod, false)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				sendStop(string(pod.UID))
+			}
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to watch pods")
+	}
+	factory.Start(k.stop)
+
+	k.wg.Add(1)
+	go func() {
+		defer k.wg.Done()
+		defer close(beaconEvents)
+		defer factory.Shutdown()
+
+		if cache.WaitForCacheSync(k.stop, registration.HasSynced) {
+			send(&beacon.Event{Action: beacon.Synced})
+			<-k.stop
+		}
+	}()
+
+	Logger.Printf("listening for pod events on node %s", k.node)
+	return beaconEvents, nil
+}
+
+// Close stops watching pods and emitting events.
+func (k *kubernetes) Close() error {
+	close(k.stop)
+	k.wg.Wait()
+	return nil
+}
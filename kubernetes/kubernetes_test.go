@@ -0,0 +1,189 @@
+package kubernetes_test
+
+import (
+	kubernetes "."
+	"context"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stest "k8s.io/client-go/testing"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const node = "node-1"
+
+// WaitForEvents waits for `n` events.
+func WaitForEvents(ch <-chan *beacon.Event, n int, timeout time.Duration) ([]*beacon.Event, error) {
+	events := make([]*beacon.Event, 0, n)
+	timer := time.After(timeout)
+	for len(events) < n {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return events, errors.New("channel closed")
+			}
+			events = append(events, event)
+		case <-timer:
+			return events, errors.New("timed out")
+		}
+	}
+	return events, nil
+}
+
+// NewPod creates a running pod on `node` with a single container.
+func NewPod(name, node string, ready bool, labels map[string]string, ports ...corev1.ContainerPort) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name),
+			Labels:    labels,
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{
+				{Name: "main", Ports: ports},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			HostIP:     "10.1.1.100",
+			PodIP:      "172.16.0.10",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+// NewClient creates a fake clientset. The returned channel is closed once
+// the runtime is watching pods.
+func NewClient(pods ...runtime.Object) (*fake.Clientset, <-chan struct{}) {
+	client := fake.NewClientset(pods...)
+	watching := make(chan struct{})
+	client.PrependWatchReactor("pods", func(action k8stest.Action) (bool, watch.Interface, error) {
+		watcher, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		close(watching)
+		return true, watcher, err
+	})
+	return client, watching
+}
+
+func TestPodEvents(t *testing.T) {
+	labels := map[string]string{"service": "www", "env": "prod"}
+	ready := NewPod("ready", node, true, labels,
+		corev1.ContainerPort{ContainerPort: 80, HostPort: 8080},
+		corev1.ContainerPort{ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+	)
+	client, watching := NewClient(
+		ready,
+		NewPod("unready", node, false, labels),
+		NewPod("unlabeled", node, true, map[string]string{"env": "prod"}),
+		NewPod("elsewhere", "node-2", true, labels),
+	)
+
+	runtime, err := kubernetes.NewWithClient(client, node, "service", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	haveEvents, err := WaitForEvents(ch, 2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEvents := []*beacon.Event{
+		{
+			Action: beacon.Start,
+			Container: &beacon.Container{
+				ID:      "ready",
+				Service: "www",
+				Labels:  labels,
+				Bindings: []*beacon.Binding{
+					{HostIP: "10.1.1.100", HostPort: 8080, ContainerPort: 80, Protocol: beacon.TCP},
+					{HostIP: "172.16.0.10", HostPort: 53, ContainerPort: 53, Protocol: beacon.UDP},
+				},
+			},
+		},
+		{Action: beacon.Synced},
+	}
+	if !reflect.DeepEqual(haveEvents, wantEvents) {
+		t.Fatalf("have events %+v, want %+v", haveEvents, wantEvents)
+	}
+
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for pod watch")
+	}
+	pods := client.CoreV1().Pods("default")
+	ctx := context.Background()
+
+	// the unready pod becomes ready
+	if _, err := pods.Update(ctx, NewPod("unready", node, true, labels), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// the ready pod stops being ready
+	if _, err := pods.Update(ctx, NewPod("ready", node, false, labels), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// the previously unready pod is deleted
+	if err := pods.Delete(ctx, "unready", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	haveEvents, err = WaitForEvents(ch, 3, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantActions := []struct {
+		action beacon.Action
+		id     string
+	}{
+		{beacon.Start, "unready"},
+		{beacon.Stop, "ready"},
+		{beacon.Stop, "unready"},
+	}
+	for n, want := range wantActions {
+		if have := haveEvents[n]; have.Action != want.action || have.Container.ID != want.id {
+			t.Errorf("have event %s %s, want %s %s", have.Action, have.Container.ID, want.action, want.id)
+		}
+	}
+}
+
+func TestServiceAnnotation(t *testing.T) {
+	pod := NewPod("annotated", node, true, nil)
+	pod.Annotations = map[string]string{"beacon/service": "db"}
+	client, _ := NewClient(pod)
+
+	runtime, err := kubernetes.NewWithClient(client, node, "service", "beacon/service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+	ch, err := runtime.EmitEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	haveEvents, err := WaitForEvents(ch, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := haveEvents[0]; have.Action != beacon.Start || have.Container.Service != "db" {
+		t.Errorf("have event %s for service %s, want start for service db", have.Action, have.Container.Service)
+	}
+}
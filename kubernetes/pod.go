@@ -0,0 +1,82 @@
+package kubernetes
+
+import (
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	errPodIgnored = errors.New("pod ignored")
+)
+
+// podReady returns true if the pod is ready to serve and is not terminating.
+func podReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podContainer converts a pod to a container. Ports with a host port are
+// bound on the node's IP. Other ports are bound on the pod's IP.
+func (k *kubernetes) podContainer(pod *corev1.Pod) (*beacon.Container, error) {
+	var service string
+	var ok bool
+	if k.serviceLabel != "" {
+		service, ok = pod.Labels[k.serviceLabel]
+	}
+	if !ok && k.serviceAnnotation != "" {
+		service, ok = pod.Annotations[k.serviceAnnotation]
+	}
+	if !ok {
+		return nil, errPodIgnored
+	}
+
+	bindings := []*beacon.Binding{}
+	for _, podContainer := range pod.Spec.Containers {
+		for _, port := range podContainer.Ports {
+			protocol, err := parseProtocol(port.Protocol)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read pod %s/%s", pod.Namespace, pod.Name)
+			}
+			binding := &beacon.Binding{
+				HostIP:        pod.Status.PodIP,
+				HostPort:      int(port.ContainerPort),
+				ContainerPort: int(port.ContainerPort),
+				Protocol:      protocol,
+			}
+			if port.HostPort != 0 {
+				binding.HostIP = port.HostIP
+				if binding.HostIP == "" || binding.HostIP == "0.0.0.0" {
+					binding.HostIP = pod.Status.HostIP
+				}
+				binding.HostPort = int(port.HostPort)
+			}
+			bindings = append(bindings, binding)
+		}
+	}
+
+	return &beacon.Container{
+		ID:       string(pod.UID),
+		Service:  service,
+		Labels:   pod.Labels,
+		Bindings: bindings,
+	}, nil
+}
+
+// parseProtocol converts a Kubernetes port protocol to a Beacon protocol.
+func parseProtocol(protocol corev1.Protocol) (beacon.Protocol, error) {
+	switch protocol {
+	case "", corev1.ProtocolTCP:
+		return beacon.TCP, nil
+	case corev1.ProtocolUDP:
+		return beacon.UDP, nil
+	}
+	return "", errors.Errorf("unsupported protocol %s", protocol)
+}
@@ -0,0 +1,108 @@
+// Package metrics holds Beacon's Prometheus metrics. Metrics are registered
+// with Registry rather than the global Prometheus registry so that Beacon only
+// exposes its own metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "beacon"
+
+// Registry holds all of Beacon's metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Events counts events received from the runtime by action.
+	Events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Events received from the runtime.",
+	}, []string{"action"})
+
+	// Ignored counts containers which the runtime ignored, such as those
+	// missing a service label.
+	Ignored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "containers_ignored_total",
+		Help:      "Containers ignored by the runtime.",
+	})
+
+	// Containers is the number of containers Beacon is tracking.
+	Containers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "containers",
+		Help:      "Containers tracked by Beacon.",
+	})
+
+	// Routed counts events accepted by each route.
+	Routed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "route",
+		Name:      "events_total",
+		Help:      "Events queued for delivery on a route.",
+	}, []string{"route"})
+
+	// Deliveries counts delivered and failed events on each route. The
+	// result label is either "success" or "failure".
+	Deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "route",
+		Name:      "deliveries_total",
+		Help:      "Events delivered or failed on a route.",
+	}, []string{"route", "result"})
+
+	// Retries counts failed delivery attempts which were retried.
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "route",
+		Name:      "retries_total",
+		Help:      "Delivery attempts retried on a route.",
+	}, []string{"route"})
+
+	// Dropped counts events dropped because a route's queue was full.
+	Dropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "route",
+		Name:      "dropped_total",
+		Help:      "Events dropped from a full route queue.",
+	}, []string{"route"})
+
+	// QueueDepth is the number of events waiting for delivery on each route.
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "route",
+		Name:      "queue_depth",
+		Help:      "Events waiting for delivery on a route.",
+	}, []string{"route"})
+
+	// Latency observes how long each delivery attempt takes.
+	Latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "route",
+		Name:      "delivery_seconds",
+		Help:      "Time taken by a route's backend to process an event.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		Events,
+		Ignored,
+		Containers,
+		Routed,
+		Deliveries,
+		Retries,
+		Dropped,
+		QueueDepth,
+		Latency,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
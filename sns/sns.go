@@ -3,22 +3,41 @@ package sns
 import (
 	"encoding/json"
 	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/BlueDragonX/beacon/dedup"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	awssns "github.com/aws/aws-sdk-go/service/sns"
 	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"sync"
 )
 
+// MaxLabels is the most labels which may be sent as message attributes. SNS
+// allows ten attributes per message and three are always sent.
+const MaxLabels = 7
+
+// invalidAttributeChars are replaced in the names of label attributes.
+var invalidAttributeChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
 // New creates an SNS backend that queues events in the SNS `topic` which lives
-// in `region`.
-func New(region, topic string) beacon.Backend {
-	return NewWithEndpoint("", region, topic)
+// in `region`. Each message has the `action`, `service` and `container_id`
+// attributes as well as one for each of the named `labels` which the container
+// has. Messages are published with `subject` if it is not empty.
+func New(region, topic string, labels []string, subject string) beacon.Backend {
+	return NewWithEndpoint("", region, topic, labels, subject)
 }
 
 // NewWithEndpoint works like New but allows you to override the AWS HTTP
 // endpoint to send requests to.
-func NewWithEndpoint(endpoint, region, topic string) beacon.Backend {
-	cfg := &aws.Config{}
+//
+// The AWS client does not retry failed requests. Retries are left to the
+// route's queue.
+func NewWithEndpoint(endpoint, region, topic string, labels []string, subject string) beacon.Backend {
+	cfg := &aws.Config{
+		MaxRetries: aws.Int(0),
+	}
 	if endpoint != "" {
 		cfg.Endpoint = aws.String(endpoint)
 	}
@@ -26,38 +45,105 @@ func NewWithEndpoint(endpoint, region, topic string) beacon.Backend {
 		cfg.Region = aws.String(region)
 	}
 	return &sns{
-		client: awssns.New(session.New(), cfg),
-		topic:  topic,
+		client:  awssns.New(session.New(), cfg),
+		topic:   topic,
+		fifo:    strings.HasSuffix(topic, ".fifo"),
+		labels:  labels,
+		subject: subject,
+		lock:    &sync.Mutex{},
+		sent:    dedup.New(),
 	}
 }
 
 // SNS sends container events to an AWS SNS topic. Events are serialized as
 // JSON.
 type sns struct {
-	client *awssns.SNS
-	topic  string
+	client  *awssns.SNS
+	topic   string
+	fifo    bool
+	labels  []string
+	subject string
+	lock    *sync.Mutex
+	sent    *dedup.IDs
 }
 
 // ProcessEvent serializes an event in JSON and sends it to the configured SNS
-// topic.
+// topic. Errors which will not succeed on retry are marked permanent.
 func (s *sns) ProcessEvent(event *beacon.Event) error {
 	message, err := json.Marshal(event)
 	if err != nil {
-		return errors.Wrap(err, "failed to serialize event")
+		return beacon.Permanent(errors.Wrap(err, "failed to serialize event"))
 	}
 
-	out, err := s.client.Publish(&awssns.PublishInput{
-		Message:  aws.String(string(message)),
-		TopicArn: aws.String(s.topic),
-	})
+	input := &awssns.PublishInput{
+		Message:           aws.String(string(message)),
+		TopicArn:          aws.String(s.topic),
+		MessageAttributes: s.attributes(event),
+	}
+	if s.subject != "" {
+		input.Subject = aws.String(s.subject)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var dedupID string
+	if s.fifo {
+		dedupID = s.sent.ID(event.Container.ID, message)
+		input.MessageGroupId = aws.String(event.Container.ID)
+		input.MessageDeduplicationId = aws.String(dedupID)
+	}
+
+	out, err := s.client.Publish(input)
 	if err != nil {
+		if isPermanent(err) {
+			err = beacon.Permanent(err)
+		}
 		return errors.Wrap(err, "failed to publish event")
 	} else if out.MessageId == nil || aws.StringValue(out.MessageId) == "" {
 		return errors.New("failed to publish event: no message id returned")
 	}
+	if s.fifo {
+		s.sent.Sent(event.Container.ID, dedupID)
+	}
 	return nil
 }
 
+// attributes returns the message attributes of an event. Attributes with
+// empty values are left out as SNS rejects them. Labels do not replace the
+// action, service or container_id attributes.
+func (s *sns) attributes(event *beacon.Event) map[string]*awssns.MessageAttributeValue {
+	values := map[string]string{}
+	for _, label := range s.labels {
+		if value, ok := event.Container.Labels[label]; ok {
+			values[invalidAttributeChars.ReplaceAllString(label, "_")] = value
+		}
+	}
+	values["action"] = string(event.Action)
+	values["service"] = event.Container.Service
+	values["container_id"] = event.Container.ID
+
+	attrs := make(map[string]*awssns.MessageAttributeValue, len(values))
+	for name, value := range values {
+		if value != "" {
+			attrs[name] = &awssns.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(value),
+			}
+		}
+	}
+	return attrs
+}
+
+// isPermanent returns true if the error is a client error which will fail
+// again if retried. Throttling errors are not permanent.
+func isPermanent(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		status := reqErr.StatusCode()
+		return status >= 400 && status < 500 && status != 429 && reqErr.Code() != "Throttling"
+	}
+	return false
+}
+
 // Close is a noop for SNS.
 func (s *sns) Close() error {
 	return nil
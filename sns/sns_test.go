@@ -2,6 +2,8 @@ package sns_test
 
 import (
 	sns "."
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -10,6 +12,9 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -51,7 +56,12 @@ func WaitForEvents(ch <-chan *beacon.Event, n int, timeout time.Duration) ([]*be
 
 // NewServer create a new test HTTP server which responds to SNS Publish messages.
 func NewServer(t *testing.T, events chan<- *beacon.Event) *httptest.Server {
-	handler := func(w http.ResponseWriter, r *http.Request) {
+	return httptest.NewServer(PublishHandler(t, events))
+}
+
+// PublishHandler creates an HTTP handler which responds to SNS Publish messages.
+func PublishHandler(t *testing.T, events chan<- *beacon.Event) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		msg := r.PostFormValue("Message")
 		event := &beacon.Event{}
 
@@ -93,9 +103,88 @@ func NewServer(t *testing.T, events chan<- *beacon.Event) *httptest.Server {
 			fmt.Fprintf(w, "unable to decode event: %s", err)
 		}
 	}
+}
+
+// NewErrorServer creates a test HTTP server which responds to the first
+// `failures` requests with an SNS error of the given HTTP `status`. Later
+// requests are handled like NewServer.
+func NewErrorServer(t *testing.T, events chan<- *beacon.Event, failures, status int) *httptest.Server {
+	publish := PublishHandler(t, events)
+	lock := &sync.Mutex{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fail := failures > 0
+		failures--
+		lock.Unlock()
+
+		if !fail {
+			publish(w, r)
+			return
+		}
+
+		type Error struct {
+			Type    string
+			Code    string
+			Message string
+		}
+
+		type ErrorResponse struct {
+			Error     Error
+			RequestId string
+		}
+
+		code := "InternalError"
+		if status < 500 {
+			code = "InvalidParameter"
+		}
+		response := ErrorResponse{
+			Error: Error{
+				Type:    "Sender",
+				Code:    code,
+				Message: "test error",
+			},
+			RequestId: RandomID(),
+		}
+
+		w.WriteHeader(status)
+		xenc := xml.NewEncoder(w)
+		if err := xenc.Encode(response); err != nil {
+			t.Fatalf("failed to encode xml response: %s", err)
+		}
+	}
 	return httptest.NewServer(http.HandlerFunc(handler))
 }
 
+// NewFormServer creates a test HTTP server which responds like NewServer and
+// sends the form of each Publish request to `forms`.
+func NewFormServer(t *testing.T, forms chan<- url.Values) *httptest.Server {
+	publish := PublishHandler(t, nil)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %s", err)
+		}
+		forms <- r.PostForm
+		publish(w, r)
+	}))
+}
+
+// MessageAttributes returns the string message attributes in a Publish form.
+func MessageAttributes(form url.Values) map[string]string {
+	attrs := map[string]string{}
+	for n := 1; ; n++ {
+		prefix := fmt.Sprintf("MessageAttributes.entry.%d.", n)
+		name := form.Get(prefix + "Name")
+		if name == "" {
+			return attrs
+		}
+		if dataType := form.Get(prefix + "Value.DataType"); dataType != "String" {
+			attrs[name] = "invalid data type " + dataType
+		} else {
+			attrs[name] = form.Get(prefix + "Value.StringValue")
+		}
+	}
+}
+
 func ContainersEqual(a *beacon.Container, b *beacon.Container) error {
 	if a.ID != b.ID {
 		return errors.Errorf("container.ID inequal: %s != %s", a.ID, b.ID)
@@ -149,7 +238,7 @@ func testEvents(t *testing.T, events []*beacon.Event) {
 	eventsChan := make(chan *beacon.Event, 1)
 	server := NewServer(t, eventsChan)
 	defer server.Close()
-	backend := sns.NewWithEndpoint(server.URL, TEST_REGION, TEST_TOPIC)
+	backend := sns.NewWithEndpoint(server.URL, TEST_REGION, TEST_TOPIC, nil, "")
 
 	go func() {
 		for _, event := range events {
@@ -220,3 +309,174 @@ func TestTwoEvents(t *testing.T) {
 		},
 	})
 }
+
+func TestRetryableError(t *testing.T) {
+	t.Parallel()
+	eventsChan := make(chan *beacon.Event, 1)
+	server := NewErrorServer(t, eventsChan, 1, 500)
+	defer server.Close()
+	backend := sns.NewWithEndpoint(server.URL, TEST_REGION, TEST_TOPIC, nil, "")
+
+	event := &beacon.Event{
+		Action: beacon.Start,
+		Container: &beacon.Container{
+			ID:      RandomID(),
+			Service: "test",
+			Labels: map[string]string{
+				"service": "test",
+				"test":    "TestRetryableError",
+			},
+			Bindings: []*beacon.Binding{
+				{HostIP: "0.0.0.0", HostPort: 54392, ContainerPort: 80, Protocol: beacon.TCP},
+			},
+		},
+	}
+
+	route, err := beacon.NewQueue(beacon.NewRoute(nil, backend), beacon.QueueConfig{
+		Retry: beacon.RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := route.ProcessEvent(event); err != nil {
+		t.Fatal(err)
+	}
+
+	haveEvents, err := WaitForEvents(eventsChan, 1, 5*time.Second)
+	if err != nil {
+		t.Error(err)
+	} else if err := EventArraysEqual(haveEvents, []*beacon.Event{event}); err != nil {
+		t.Error(err)
+	}
+	if err := route.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	t.Parallel()
+	server := NewErrorServer(t, nil, 1, 400)
+	defer server.Close()
+	backend := sns.NewWithEndpoint(server.URL, TEST_REGION, TEST_TOPIC, nil, "")
+
+	err := backend.ProcessEvent(&beacon.Event{
+		Action: beacon.Start,
+		Container: &beacon.Container{
+			ID:      RandomID(),
+			Service: "test",
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	} else if !beacon.IsPermanent(err) {
+		t.Errorf("expected permanent error: %s", err)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	t.Parallel()
+	server := NewErrorServer(t, nil, 1, 503)
+	defer server.Close()
+	backend := sns.NewWithEndpoint(server.URL, TEST_REGION, TEST_TOPIC, nil, "")
+
+	err := backend.ProcessEvent(&beacon.Event{
+		Action: beacon.Start,
+		Container: &beacon.Container{
+			ID:      RandomID(),
+			Service: "test",
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	} else if beacon.IsPermanent(err) {
+		t.Errorf("expected retryable error: %s", err)
+	}
+}
+
+func TestMessageAttributes(t *testing.T) {
+	t.Parallel()
+	forms := make(chan url.Values, 1)
+	server := NewFormServer(t, forms)
+	defer server.Close()
+	backend := sns.NewWithEndpoint(server.URL, TEST_REGION, TEST_TOPIC, []string{"com.example/env", "missing", "service"}, "container event")
+
+	err := backend.ProcessEvent(&beacon.Event{
+		Action: beacon.Start,
+		Container: &beacon.Container{
+			ID:      "512b64138152",
+			Service: "www",
+			Labels: map[string]string{
+				"service":         "web",
+				"com.example/env": "prod",
+				"group":           "ops",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := <-forms
+	want := map[string]string{
+		"action":          "start",
+		"service":         "www",
+		"container_id":    "512b64138152",
+		"com.example_env": "prod",
+	}
+	if have := MessageAttributes(form); !reflect.DeepEqual(have, want) {
+		t.Errorf("have attributes %v, want %v", have, want)
+	}
+	if have := form.Get("Subject"); have != "container event" {
+		t.Errorf("have subject %q, want %q", have, "container event")
+	}
+	if _, ok := form["MessageGroupId"]; ok {
+		t.Error("have message group id on a standard topic")
+	}
+	if _, ok := form["MessageDeduplicationId"]; ok {
+		t.Error("have deduplication id on a standard topic")
+	}
+}
+
+func TestFIFOTopic(t *testing.T) {
+	t.Parallel()
+	forms := make(chan url.Values, 1)
+	server := NewFormServer(t, forms)
+	defer server.Close()
+	backend := sns.NewWithEndpoint(server.URL, TEST_REGION, TEST_TOPIC+".fifo", nil, "")
+
+	start := &beacon.Event{
+		Action: beacon.Start,
+		Container: &beacon.Container{
+			ID:      RandomID(),
+			Service: "test",
+		},
+	}
+	stop := &beacon.Event{Action: beacon.Stop, Container: start.Container}
+	dedupIDs := []string{}
+	for _, event := range []*beacon.Event{start, stop, start} {
+		if err := backend.ProcessEvent(event); err != nil {
+			t.Fatal(err)
+		}
+		form := <-forms
+		if have := form.Get("MessageGroupId"); have != start.Container.ID {
+			t.Errorf("have message group id %q, want %q", have, start.Container.ID)
+		}
+		if form.Get("Subject") != "" {
+			t.Errorf("have subject %q, want none", form.Get("Subject"))
+		}
+		dedupIDs = append(dedupIDs, form.Get("MessageDeduplicationId"))
+	}
+
+	message, err := json.Marshal(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(message)
+	if dedupIDs[0] != hex.EncodeToString(sum[:]) {
+		t.Errorf("have deduplication id %q, want hash of the message", dedupIDs[0])
+	}
+	// the container restarted with the same state
+	if dedupIDs[2] == dedupIDs[0] || dedupIDs[2] == dedupIDs[1] {
+		t.Errorf("have repeated deduplication ids %v", dedupIDs)
+	}
+}
@@ -0,0 +1,413 @@
+package sqs
+
+import (
+	"encoding/json"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/BlueDragonX/beacon/dedup"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxBatchSize is the most messages SQS accepts in a SendMessageBatch request.
+const MaxBatchSize = 10
+
+// MaxBatchBytes is the largest total payload, message bodies and attributes,
+// SQS accepts in a SendMessageBatch request.
+const MaxBatchBytes = 256 * 1024
+
+// MaxBuffered is the most events a batching backend holds before it rejects
+// new ones.
+var MaxBuffered = 100
+
+// defaultRetry is used to retry batched events until the route's queue sets
+// its own policy.
+var defaultRetry = beacon.RetryPolicy{
+	Attempts:   beacon.DefaultRetryAttempts,
+	Backoff:    beacon.DefaultRetryBackoff,
+	MaxBackoff: beacon.DefaultRetryMaxBackoff,
+}
+
+// New creates an SQS backend that sends events to the SQS `queue` URL which
+// lives in `region`. If batchDelay is positive then events are buffered and
+// sent with SendMessageBatch once ten events are waiting or batchDelay has
+// passed.
+func New(region, queue string, batchDelay time.Duration) beacon.Backend {
+	return NewWithEndpoint("", region, queue, batchDelay)
+}
+
+// NewWithEndpoint works like New but allows you to override the AWS HTTP
+// endpoint to send requests to.
+//
+// The AWS client does not retry failed requests. Unbatched events are retried
+// by the route's queue. Batched events are accepted as soon as they are
+// buffered, so the backend retries the ones which fail itself, using the
+// retry policy of the route's queue. Those which fail permanently, run out of
+// attempts, or are still buffered when the backend is closed, are handed to
+// the failure handler set by the route's queue, which dead letters them.
+func NewWithEndpoint(endpoint, region, queue string, batchDelay time.Duration) beacon.Backend {
+	cfg := &aws.Config{
+		MaxRetries: aws.Int(0),
+	}
+	if endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+	}
+	if region != "" {
+		cfg.Region = aws.String(region)
+	}
+	s := &sqs{
+		client:     awssqs.New(session.New(), cfg),
+		queue:      queue,
+		fifo:       strings.HasSuffix(queue, ".fifo"),
+		batchDelay: batchDelay,
+		lock:       &sync.Mutex{},
+		sent:       dedup.New(),
+		retry:      defaultRetry,
+		failed:     discard,
+		flush:      make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		wg:         &sync.WaitGroup{},
+	}
+	if batchDelay > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+	return s
+}
+
+// SQS sends container events to an AWS SQS queue. Events are serialized as
+// JSON.
+type sqs struct {
+	client     *awssqs.SQS
+	queue      string
+	fifo       bool
+	batchDelay time.Duration
+	lock       *sync.Mutex
+	sent       *dedup.IDs
+	pending    []*message
+	retry      beacon.RetryPolicy
+	failed     func(event *beacon.Event, err error, attempts int)
+	flush      chan struct{}
+	stop       chan struct{}
+	wg         *sync.WaitGroup
+}
+
+// message is an event waiting to be sent. A message which failed is not sent
+// again before retryAt. The size is the message's share of a batch's payload.
+type message struct {
+	event    *beacon.Event
+	body     string
+	size     int
+	dedupID  string
+	attempts int
+	retryAt  time.Time
+	err      error
+}
+
+// ProcessEvent serializes an event in JSON and sends it to the configured SQS
+// queue, or buffers it for the next batch. Errors which will not succeed on
+// retry are marked permanent.
+func (s *sqs) ProcessEvent(event *beacon.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return beacon.Permanent(errors.Wrap(err, "failed to serialize event"))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	msg := &message{event: event, body: string(body), size: len(body)}
+	for name, attr := range attributes(event) {
+		msg.size += len(name) + len(aws.StringValue(attr.DataType)) + len(aws.StringValue(attr.StringValue))
+	}
+	if s.fifo {
+		msg.dedupID = s.sent.ID(event.Container.ID, body)
+	}
+
+	if s.batchDelay <= 0 {
+		if err := s.send(msg); err != nil {
+			return err
+		}
+		s.setSent(msg)
+		return nil
+	}
+
+	if len(s.pending) >= MaxBuffered {
+		return errors.New("failed to send event: too many events waiting to be sent")
+	}
+	s.pending = append(s.pending, msg)
+	s.setSent(msg)
+	if len(s.pending) >= MaxBatchSize {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// SetFailureHandler sets the policy used to retry batched events and the
+// function which is given those that could not be sent.
+func (s *sqs) SetFailureHandler(retry beacon.RetryPolicy, handler func(event *beacon.Event, err error, attempts int)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.retry = retry
+	s.failed = handler
+}
+
+// fail hands messages which could not be sent to the failure handler.
+func (s *sqs) fail(msgs []*message, err error) {
+	s.lock.Lock()
+	failed := s.failed
+	s.lock.Unlock()
+	for _, msg := range msgs {
+		failed(msg.event, err, msg.attempts)
+	}
+}
+
+// discard is the failure handler used when none is set.
+func discard(event *beacon.Event, err error, attempts int) {
+	Logger.Printf("discarding event %s for container %s after %d attempts: %s", event.Action, event.Container.ID, attempts, err)
+}
+
+// setSent records the deduplication ID of a message sent to a FIFO queue.
+func (s *sqs) setSent(msg *message) {
+	if s.fifo {
+		s.sent.Sent(msg.event.Container.ID, msg.dedupID)
+	}
+}
+
+// attributes returns the message attributes of an event.
+func attributes(event *beacon.Event) map[string]*awssqs.MessageAttributeValue {
+	attrs := map[string]*awssqs.MessageAttributeValue{}
+	for name, value := range map[string]string{
+		"action":  string(event.Action),
+		"service": event.Container.Service,
+	} {
+		// SQS rejects empty attribute values
+		if value != "" {
+			attrs[name] = &awssqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(value),
+			}
+		}
+	}
+	return attrs
+}
+
+// send sends a single message.
+func (s *sqs) send(msg *message) error {
+	input := &awssqs.SendMessageInput{
+		QueueUrl:          aws.String(s.queue),
+		MessageBody:       aws.String(msg.body),
+		MessageAttributes: attributes(msg.event),
+	}
+	if s.fifo {
+		input.MessageGroupId = aws.String(msg.event.Container.ID)
+		input.MessageDeduplicationId = aws.String(msg.dedupID)
+	}
+
+	out, err := s.client.SendMessage(input)
+	if err != nil {
+		if isPermanent(err) {
+			err = beacon.Permanent(err)
+		}
+		return errors.Wrap(err, "failed to send event")
+	} else if out.MessageId == nil || aws.StringValue(out.MessageId) == "" {
+		return errors.New("failed to send event: no message id returned")
+	}
+	return nil
+}
+
+// sendBatch sends up to MaxBatchSize messages in one request. It returns the
+// messages which failed with a retryable error, with the error set. Messages
+// which SQS rejects are handed to the failure handler.
+func (s *sqs) sendBatch(msgs []*message) ([]*message, error) {
+	input := &awssqs.SendMessageBatchInput{
+		QueueUrl: aws.String(s.queue),
+		Entries:  make([]*awssqs.SendMessageBatchRequestEntry, len(msgs)),
+	}
+	for n, msg := range msgs {
+		msg.attempts++
+		entry := &awssqs.SendMessageBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(n)),
+			MessageBody:       aws.String(msg.body),
+			MessageAttributes: attributes(msg.event),
+		}
+		if s.fifo {
+			entry.MessageGroupId = aws.String(msg.event.Container.ID)
+			entry.MessageDeduplicationId = aws.String(msg.dedupID)
+		}
+		input.Entries[n] = entry
+	}
+
+	out, err := s.client.SendMessageBatch(input)
+	if err != nil {
+		if isPermanent(err) {
+			err = beacon.Permanent(err)
+		}
+		return nil, errors.Wrap(err, "failed to send events")
+	}
+
+	var retry []*message
+	for _, failed := range out.Failed {
+		n, err := strconv.Atoi(aws.StringValue(failed.Id))
+		if err != nil || n < 0 || n >= len(msgs) {
+			continue
+		}
+		msg := msgs[n]
+		err = errors.Errorf("failed to send event: %s: %s", aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+		if aws.BoolValue(failed.SenderFault) {
+			s.fail([]*message{msg}, beacon.Permanent(err))
+		} else {
+			msg.err = err
+			retry = append(retry, msg)
+		}
+	}
+	return retry, nil
+}
+
+// sendPending sends the buffered messages in batches. Messages which fail
+// with a retryable error are kept and retried after the delay given by the
+// retry policy, or handed to the failure handler once they have used all of
+// their attempts. Unless `final` is true, messages which are waiting for their
+// delay to pass are not sent.
+func (s *sqs) sendPending(final bool) {
+	s.lock.Lock()
+	pending := s.pending
+	s.pending = nil
+	retry := s.retry
+	s.lock.Unlock()
+
+	now := time.Now()
+	attempted := map[*message]struct{}{}
+	for {
+		batch := s.nextBatch(pending, attempted, now, final)
+		if len(batch) == 0 {
+			break
+		}
+		failed, err := s.sendBatch(batch)
+		if err != nil && beacon.IsPermanent(err) {
+			s.fail(batch, err)
+			failed = nil
+		} else if err != nil {
+			for _, msg := range batch {
+				msg.err = err
+			}
+			failed = batch
+		}
+
+		done := map[*message]struct{}{}
+		for _, msg := range batch {
+			attempted[msg] = struct{}{}
+			done[msg] = struct{}{}
+		}
+		for _, msg := range failed {
+			if msg.attempts >= retry.Attempts {
+				s.fail([]*message{msg}, msg.err)
+				continue
+			}
+			delete(done, msg)
+			msg.retryAt = now.Add(retry.Delay(msg.attempts))
+		}
+		kept := []*message{}
+		for _, msg := range pending {
+			if _, ok := done[msg]; !ok {
+				kept = append(kept, msg)
+			}
+		}
+		pending = kept
+
+		if err != nil && !beacon.IsPermanent(err) {
+			// try the rest again later rather than failing each batch
+			Logger.Printf("%s, will retry", err)
+			break
+		}
+	}
+
+	s.lock.Lock()
+	s.pending = append(pending, s.pending...)
+	s.lock.Unlock()
+}
+
+// nextBatch returns the messages, in order, which have not been attempted and,
+// unless `final` is true, are not waiting to be retried. The batch holds up to
+// MaxBatchSize messages and MaxBatchBytes of payload, though a larger message
+// is sent on its own. For FIFO queues the batch holds only the first unsent
+// message of each group, so that a message which fails holds back the rest of
+// its group until it is sent or given up on.
+func (s *sqs) nextBatch(msgs []*message, attempted map[*message]struct{}, now time.Time, final bool) []*message {
+	batch := []*message{}
+	size := 0
+	groups := map[string]struct{}{}
+	for _, msg := range msgs {
+		if len(batch) >= MaxBatchSize {
+			break
+		}
+		group := msg.event.Container.ID
+		_, held := groups[group]
+		if s.fifo {
+			groups[group] = struct{}{}
+		}
+		if _, ok := attempted[msg]; ok || held || (!final && msg.retryAt.After(now)) {
+			continue
+		}
+		if len(batch) > 0 && size+msg.size > MaxBatchBytes {
+			continue
+		}
+		batch = append(batch, msg)
+		size += msg.size
+	}
+	return batch
+}
+
+// run sends buffered messages every batchDelay or as soon as a full batch is
+// waiting. The remaining messages are sent once more when the backend is
+// closed and those which are still not sent are handed to the failure handler.
+func (s *sqs) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.batchDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.flush:
+		case <-s.stop:
+			s.sendPending(true)
+			s.lock.Lock()
+			unsent := s.pending
+			s.pending = nil
+			s.lock.Unlock()
+			s.fail(unsent, errors.New("failed to send event: backend closed"))
+			return
+		}
+		s.sendPending(false)
+	}
+}
+
+// isPermanent returns true if the error is a client error which will fail
+// again if retried. Throttling errors are not permanent.
+func isPermanent(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		status := reqErr.StatusCode()
+		switch reqErr.Code() {
+		case "Throttling", "ThrottlingException", "RequestThrottled":
+			return false
+		}
+		return status >= 400 && status < 500 && status != 429
+	}
+	return false
+}
+
+// Close sends the buffered events. Events which cannot be sent are handed to
+// the failure handler.
+func (s *sqs) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+	return nil
+}
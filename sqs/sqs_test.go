@@ -0,0 +1,535 @@
+package sqs_test
+
+import (
+	sqs "."
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/BlueDragonX/beacon/beacon"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_REGION = "us-east-1"
+	TEST_QUEUE  = "/698519295917/TestQueue"
+)
+
+func init() {
+	// requests are signed even though the test server does not check them
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		os.Setenv("AWS_ACCESS_KEY_ID", "test")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	}
+}
+
+// Attribute is a message attribute as received by FakeSQS.
+type Attribute struct {
+	DataType    string
+	StringValue string
+}
+
+// Message is a message as received by FakeSQS.
+type Message struct {
+	MessageBody            string
+	MessageAttributes      map[string]Attribute
+	MessageGroupId         string
+	MessageDeduplicationId string
+}
+
+// Event decodes the message body.
+func (m *Message) Event(t *testing.T) *beacon.Event {
+	event := &beacon.Event{}
+	if err := json.Unmarshal([]byte(m.MessageBody), event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// FakeSQS serves the SendMessage and SendMessageBatch actions of the SQS JSON
+// API.
+type FakeSQS struct {
+	*httptest.Server
+	lock        sync.Mutex
+	status      int
+	failEntries []bool
+	batches     []int
+	messages    []*Message
+}
+
+func NewFakeSQS() *FakeSQS {
+	fake := &FakeSQS{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	return fake
+}
+
+// SetStatus makes the server fail requests with the status. A zero status
+// restores normal operation.
+func (f *FakeSQS) SetStatus(status int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.status = status
+}
+
+// FailEntry fails the first entry of the next batch. It is a sender fault if
+// `senderFault` is true.
+func (f *FakeSQS) FailEntry(senderFault bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failEntries = append(f.failEntries, senderFault)
+}
+
+// Batches returns the number of entries in each batch request.
+func (f *FakeSQS) Batches() []int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]int{}, f.batches...)
+}
+
+// Messages returns the messages received in order.
+func (f *FakeSQS) Messages() []*Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]*Message{}, f.messages...)
+}
+
+// WaitForMessages waits for the server to receive `n` messages.
+func (f *FakeSQS) WaitForMessages(t *testing.T, n int) []*Message {
+	var messages []*Message
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if messages = f.Messages(); len(messages) >= n {
+			return messages
+		}
+	}
+	t.Fatalf("have %d messages, want %d", len(messages), n)
+	return nil
+}
+
+func (f *FakeSQS) serve(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if f.status != 0 {
+		code := "InternalError"
+		if f.status < 500 {
+			code = "InvalidParameterValue"
+		}
+		w.WriteHeader(f.status)
+		fmt.Fprintf(w, `{"__type": "com.amazonaws.sqs#%s", "message": "test error"}`, code)
+		return
+	}
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "AmazonSQS.SendMessage":
+		msg := &Message{}
+		if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.messages = append(f.messages, msg)
+		json.NewEncoder(w).Encode(map[string]string{
+			"MessageId":        fmt.Sprint(len(f.messages)),
+			"MD5OfMessageBody": md5Hex(msg.MessageBody),
+		})
+	case "AmazonSQS.SendMessageBatch":
+		input := struct {
+			Entries []struct {
+				Id string
+				Message
+			}
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.batches = append(f.batches, len(input.Entries))
+
+		successful := []map[string]string{}
+		failed := []map[string]interface{}{}
+		for n, entry := range input.Entries {
+			if n == 0 && len(f.failEntries) > 0 {
+				failed = append(failed, map[string]interface{}{
+					"Id":          entry.Id,
+					"Code":        "TestError",
+					"Message":     "test error",
+					"SenderFault": f.failEntries[0],
+				})
+				f.failEntries = f.failEntries[1:]
+				continue
+			}
+			msg := entry.Message
+			f.messages = append(f.messages, &msg)
+			successful = append(successful, map[string]string{
+				"Id":               entry.Id,
+				"MessageId":        fmt.Sprint(len(f.messages)),
+				"MD5OfMessageBody": md5Hex(msg.MessageBody),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Successful": successful,
+			"Failed":     failed,
+		})
+	default:
+		http.Error(w, "unsupported action", http.StatusBadRequest)
+	}
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func newEvent(action beacon.Action, id, service string) *beacon.Event {
+	return &beacon.Event{
+		Action: action,
+		Container: &beacon.Container{
+			ID:      id,
+			Service: service,
+			Labels:  map[string]string{"service": service},
+			Bindings: []*beacon.Binding{
+				{HostIP: "10.1.1.100", HostPort: 54392, ContainerPort: 80, Protocol: beacon.TCP},
+			},
+		},
+	}
+}
+
+func TestSQS(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE, 0)
+	defer backend.Close()
+
+	events := []*beacon.Event{
+		newEvent(beacon.Start, "a", "www"),
+		{Action: beacon.Stop, Container: &beacon.Container{ID: "a"}},
+	}
+	for _, event := range events {
+		if err := backend.ProcessEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := fake.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("have %d messages, want 2", len(messages))
+	}
+	for n, msg := range messages {
+		event := msg.Event(t)
+		if event.Action != events[n].Action || !event.Container.Equal(events[n].Container) {
+			t.Errorf("message %d: have event %s %+v, want %s %+v", n, event.Action, event.Container, events[n].Action, events[n].Container)
+		}
+		if msg.MessageGroupId != "" || msg.MessageDeduplicationId != "" {
+			t.Errorf("message %d: have FIFO ids on a standard queue", n)
+		}
+	}
+
+	want := map[string]Attribute{
+		"action":  {"String", "start"},
+		"service": {"String", "www"},
+	}
+	if have := messages[0].MessageAttributes; len(have) != 2 || have["action"] != want["action"] || have["service"] != want["service"] {
+		t.Errorf("have attributes %+v, want %+v", have, want)
+	}
+	// stop events have no service
+	if have := messages[1].MessageAttributes; len(have) != 1 || have["action"] != (Attribute{"String", "stop"}) {
+		t.Errorf("have attributes %+v, want action only", have)
+	}
+}
+
+func TestSQSFIFO(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE+".fifo", 0)
+	defer backend.Close()
+
+	events := []*beacon.Event{
+		newEvent(beacon.Start, "a", "www"),
+		newEvent(beacon.Start, "b", "www"),
+		newEvent(beacon.Stop, "a", "www"),
+		newEvent(beacon.Start, "a", "www"),
+	}
+
+	// a failed event keeps its deduplication id when retried
+	fake.SetStatus(500)
+	if err := backend.ProcessEvent(events[0]); err == nil {
+		t.Fatal("expected error")
+	}
+	fake.SetStatus(0)
+	for _, event := range events {
+		if err := backend.ProcessEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := fake.Messages()
+	if len(messages) != len(events) {
+		t.Fatalf("have %d messages, want %d", len(messages), len(events))
+	}
+	for n, msg := range messages {
+		if msg.MessageGroupId != events[n].Container.ID {
+			t.Errorf("message %d: have group id %q, want %q", n, msg.MessageGroupId, events[n].Container.ID)
+		}
+	}
+	sum := sha256.Sum256([]byte(messages[0].MessageBody))
+	if have, want := messages[0].MessageDeduplicationId, hex.EncodeToString(sum[:]); have != want {
+		t.Errorf("have deduplication id %q, want %q", have, want)
+	}
+	// the container restarted with the same state
+	if messages[3].MessageBody != messages[0].MessageBody {
+		t.Fatal("restarted container has a different body")
+	}
+	if messages[3].MessageDeduplicationId == messages[0].MessageDeduplicationId {
+		t.Error("restarted container has the same deduplication id")
+	}
+}
+
+func TestSQSBatch(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE+".fifo", time.Hour)
+
+	events := make([]*beacon.Event, 12)
+	for n := range events {
+		events[n] = newEvent(beacon.Start, fmt.Sprint(n), "www")
+		if err := backend.ProcessEvent(events[n]); err != nil {
+			t.Fatal(err)
+		}
+		// a full batch is sent right away
+		if n == 9 {
+			fake.WaitForMessages(t, 10)
+		}
+	}
+
+	// the rest are sent when the backend closes
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if have := fake.Batches(); len(have) != 2 || have[0] != 10 || have[1] != 2 {
+		t.Errorf("have batches %v, want [10 2]", have)
+	}
+	messages := fake.Messages()
+	if len(messages) != len(events) {
+		t.Fatalf("have %d messages, want %d", len(messages), len(events))
+	}
+	for n, msg := range messages {
+		if event := msg.Event(t); event.Container.ID != events[n].Container.ID {
+			t.Errorf("message %d: have container %s, want %s", n, event.Container.ID, events[n].Container.ID)
+		}
+		if msg.MessageGroupId != events[n].Container.ID || msg.MessageDeduplicationId == "" {
+			t.Errorf("message %d: missing FIFO ids", n)
+		}
+		if msg.MessageAttributes["action"].StringValue != "start" {
+			t.Errorf("message %d: have attributes %+v", n, msg.MessageAttributes)
+		}
+	}
+}
+
+func TestSQSBatchRetry(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE, 10*time.Millisecond)
+	defer backend.Close()
+	failed := make(chan *beacon.Event, 1)
+	retry := beacon.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	backend.(beacon.FailureReporter).SetFailureHandler(retry, func(event *beacon.Event, err error, attempts int) {
+		if !beacon.IsPermanent(err) || attempts != 2 {
+			t.Errorf("have failure after %d attempts, permanent %t: %s", attempts, beacon.IsPermanent(err), err)
+		}
+		failed <- event
+	})
+
+	// the first entry is retried, the retry fails as a sender fault
+	fake.FailEntry(false)
+	fake.FailEntry(true)
+	for n := 0; n < 3; n++ {
+		if err := backend.ProcessEvent(newEvent(beacon.Start, fmt.Sprint(n), "www")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.WaitForMessages(t, 2)
+	select {
+	case event := <-failed:
+		if event.Container.ID != "0" {
+			t.Errorf("have failed container %s, want 0", event.Container.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for failed event")
+	}
+
+	// later events are not blocked by the failed one
+	if err := backend.ProcessEvent(newEvent(beacon.Start, "3", "www")); err != nil {
+		t.Fatal(err)
+	}
+	messages := fake.WaitForMessages(t, 3)
+	have := []string{}
+	for _, msg := range messages {
+		have = append(have, msg.Event(t).Container.ID)
+	}
+	if fmt.Sprint(have) != "[1 2 3]" {
+		t.Errorf("have containers %v, want [1 2 3]", have)
+	}
+}
+
+func TestSQSBatchRetryLimit(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE, 10*time.Millisecond)
+	defer backend.Close()
+	failed := make(chan error, 1)
+	retry := beacon.RetryPolicy{Attempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: time.Second}
+	backend.(beacon.FailureReporter).SetFailureHandler(retry, func(event *beacon.Event, err error, attempts int) {
+		if attempts != 3 {
+			t.Errorf("have failure after %d attempts, want 3", attempts)
+		}
+		failed <- err
+	})
+
+	// the entry fails on every attempt
+	for n := 0; n < 3; n++ {
+		fake.FailEntry(false)
+	}
+	start := time.Now()
+	if err := backend.ProcessEvent(newEvent(beacon.Start, "0", "www")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-failed:
+		if beacon.IsPermanent(err) {
+			t.Errorf("have permanent error %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for failed event")
+	}
+	// the retries wait 50ms and then 100ms
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("failed after %s, want at least 150ms", elapsed)
+	}
+	if have := fake.Batches(); len(have) != 3 {
+		t.Errorf("have batches %v, want 3", have)
+	}
+}
+
+func TestSQSBatchFIFOGroups(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE+".fifo", 50*time.Millisecond)
+	retry := beacon.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	backend.(beacon.FailureReporter).SetFailureHandler(retry, func(event *beacon.Event, err error, attempts int) {
+		t.Errorf("event for container %s failed: %s", event.Container.ID, err)
+	})
+
+	// the first event of container a fails and holds back its second
+	fake.FailEntry(false)
+	events := []*beacon.Event{
+		newEvent(beacon.Start, "a", "www"),
+		newEvent(beacon.Start, "b", "www"),
+		newEvent(beacon.Stop, "a", "www"),
+	}
+	for _, event := range events {
+		if err := backend.ProcessEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := fake.WaitForMessages(t, 3)
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	have := []string{}
+	for _, msg := range messages {
+		event := msg.Event(t)
+		have = append(have, event.Container.ID+" "+string(event.Action))
+	}
+	if fmt.Sprint(have) != "[b start a start a stop]" {
+		t.Errorf("have messages %v, want [b start a start a stop]", have)
+	}
+	if have := fake.Batches(); fmt.Sprint(have) != "[2 1 1]" {
+		t.Errorf("have batches %v, want [2 1 1]", have)
+	}
+}
+
+func TestSQSBatchBytes(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE, time.Hour)
+
+	// two events fit in the 256 KiB payload of a batch
+	for n := 0; n < 3; n++ {
+		event := newEvent(beacon.Start, fmt.Sprint(n), "www")
+		event.Container.Labels["data"] = strings.Repeat("a", 100*1024)
+		if err := backend.ProcessEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if have := fake.Batches(); fmt.Sprint(have) != "[2 1]" {
+		t.Errorf("have batches %v, want [2 1]", have)
+	}
+}
+
+func TestSQSErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE, 0)
+	defer backend.Close()
+
+	for _, test := range tests {
+		fake.SetStatus(test.status)
+		err := backend.ProcessEvent(newEvent(beacon.Start, "a", "www"))
+		if err == nil {
+			t.Errorf("status %d: expected error", test.status)
+		} else if beacon.IsPermanent(err) != test.permanent {
+			t.Errorf("status %d: have permanent %t, want %t", test.status, beacon.IsPermanent(err), test.permanent)
+		}
+	}
+}
+
+func TestSQSBatchClose(t *testing.T) {
+	t.Parallel()
+	fake := NewFakeSQS()
+	defer fake.Close()
+	backend := sqs.NewWithEndpoint(fake.URL, TEST_REGION, fake.URL+TEST_QUEUE, time.Hour)
+	failed := []string{}
+	backend.(beacon.FailureReporter).SetFailureHandler(beacon.RetryPolicy{Attempts: 3}, func(event *beacon.Event, err error, attempts int) {
+		failed = append(failed, event.Container.ID)
+	})
+
+	// events which cannot be sent when the backend closes are failed
+	fake.SetStatus(500)
+	for n := 0; n < 2; n++ {
+		if err := backend.ProcessEvent(newEvent(beacon.Start, fmt.Sprint(n), "www")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(failed) != "[0 1]" {
+		t.Errorf("have failed containers %v, want [0 1]", failed)
+	}
+}
@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/BlueDragonX/beacon/beacon"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout is used when the request timeout is zero.
+const DefaultTimeout = 10 * time.Second
+
+// SignatureHeader is the request header which holds the signature of the
+// request body.
+const SignatureHeader = "X-Beacon-Signature"
+
+// New creates a webhook backend which POSTs JSON encoded events to `url`.
+//
+// The `headers` are added to each request. If `secret` is not empty the
+// request body is signed with HMAC-SHA256 using the secret. The hex encoded
+// signature is sent in the X-Beacon-Signature header prefixed with `sha256=`.
+//
+// Each request is limited to `timeout`. The `tlsConfig` configures HTTPS
+// connections and may be nil to use the defaults.
+//
+// Requests are not retried by the backend. Retries are left to the route's
+// queue.
+func New(url string, headers map[string]string, secret string, timeout time.Duration, tlsConfig *tls.Config) beacon.Backend {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &webhook{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		url:     url,
+		headers: headers,
+		secret:  []byte(secret),
+	}
+}
+
+// NewTLSConfig creates a TLS configuration which trusts the CA certificates in
+// `caFile` and presents the client certificate in `certFile` and `keyFile`.
+// Empty paths are skipped. The system CAs are used if `caFile` is empty.
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CA file")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in CA file %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// webhook sends container events to an HTTP endpoint.
+type webhook struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+	secret  []byte
+}
+
+// ProcessEvent serializes an event in JSON and POSTs it to the webhook URL.
+// Client errors, other than timeouts and throttling, are marked permanent.
+func (w *webhook) ProcessEvent(event *beacon.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return beacon.Permanent(errors.Wrap(err, "failed to serialize event"))
+	}
+
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return beacon.Permanent(errors.Wrap(err, "failed to create request"))
+	}
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, body))
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send event")
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	err = errors.Errorf("failed to send event: %s", res.Status)
+	if isPermanent(res.StatusCode) {
+		err = beacon.Permanent(err)
+	}
+	return err
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body using the secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isPermanent returns true if the status is a client error which will fail
+// again if retried. Request timeouts and throttling are not permanent.
+func isPermanent(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// Close closes idle connections to the webhook.
+func (w *webhook) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
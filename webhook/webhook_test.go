@@ -0,0 +1,219 @@
+package webhook_test
+
+import (
+	webhook "."
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/BlueDragonX/beacon/beacon"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testEvent = &beacon.Event{
+	Action: beacon.Start,
+	Container: &beacon.Container{
+		ID:      "512b64138152",
+		Service: "www",
+		Labels:  map[string]string{"service": "www"},
+		Bindings: []*beacon.Binding{
+			{HostIP: "10.1.1.100", HostPort: 54392, ContainerPort: 80, Protocol: beacon.TCP},
+		},
+	},
+}
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	secret := "secret"
+	backend := webhook.New(server.URL, map[string]string{"Authorization": "Bearer token"}, secret, time.Second, nil)
+	defer backend.Close()
+	if err := backend.ProcessEvent(testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	req, body := <-requests, <-bodies
+	if req.Method != "POST" {
+		t.Errorf("have method %s, want POST", req.Method)
+	}
+	if have := req.Header.Get("Authorization"); have != "Bearer token" {
+		t.Errorf("have Authorization %q, want %q", have, "Bearer token")
+	}
+	if have := req.Header.Get("Content-Type"); have != "application/json" {
+		t.Errorf("have Content-Type %q, want application/json", have)
+	}
+	want := "sha256=" + webhook.Sign([]byte(secret), body)
+	if have := req.Header.Get(webhook.SignatureHeader); have != want {
+		t.Errorf("have signature %q, want %q", have, want)
+	}
+
+	event := &beacon.Event{}
+	if err := json.Unmarshal(body, event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Action != testEvent.Action || !event.Container.Equal(testEvent.Container) {
+		t.Errorf("have event %s %+v, want %s %+v", event.Action, event.Container, testEvent.Action, testEvent.Container)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	t.Parallel()
+	signatures := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures <- r.Header.Get(webhook.SignatureHeader)
+	}))
+	defer server.Close()
+
+	backend := webhook.New(server.URL, nil, "", 0, nil)
+	defer backend.Close()
+	if err := backend.ProcessEvent(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if have := <-signatures; have != "" {
+		t.Errorf("have signature %q, want none", have)
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		}))
+		backend := webhook.New(server.URL, nil, "", time.Second, nil)
+		err := backend.ProcessEvent(testEvent)
+		if err == nil {
+			t.Errorf("status %d: expected error", test.status)
+		} else if beacon.IsPermanent(err) != test.permanent {
+			t.Errorf("status %d: have permanent %t, want %t", test.status, beacon.IsPermanent(err), test.permanent)
+		}
+		backend.Close()
+		server.Close()
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	t.Parallel()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	backend := webhook.New(server.URL, nil, "", 10*time.Millisecond, nil)
+	defer backend.Close()
+	if err := backend.ProcessEvent(testEvent); err == nil {
+		t.Error("expected error")
+	} else if beacon.IsPermanent(err) {
+		t.Errorf("expected retryable error: %s", err)
+	}
+}
+
+// writeClientCert generates a self signed client certificate and writes it
+// and its key to `dir`.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "beacon"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestWebhookTLS(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "beacon-webhook-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCerts := make(chan int, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCerts <- len(r.TLS.PeerCertificates)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeClientCert(t, dir)
+
+	// the server is not trusted without the CA
+	backend := webhook.New(server.URL, nil, "", time.Second, nil)
+	if err := backend.ProcessEvent(testEvent); err == nil {
+		t.Error("expected error with untrusted server")
+	}
+	backend.Close()
+
+	tlsConfig, err := webhook.NewTLSConfig(caFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend = webhook.New(server.URL, nil, "", time.Second, tlsConfig)
+	defer backend.Close()
+	if err := backend.ProcessEvent(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if have := <-clientCerts; have != 1 {
+		t.Errorf("have %d client certificates, want 1", have)
+	}
+}